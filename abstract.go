@@ -1,27 +1,115 @@
 package evmdis
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 type EvmState interface {
 	Advance() ([]EvmState, error)
 }
 
+// WideningState is implemented by states that can be joined, so that the
+// number of states explored at each location in the program can be bounded.
+type WideningState interface {
+	EvmState
+	// Location identifies where execution of the state continues; states with
+	// the same location may be widened together.
+	Location() interface{}
+	// Widen returns a state that covers both this state and other, which has
+	// the same location.
+	Widen(other EvmState) EvmState
+}
+
+// ExecutionOptions bounds the exploration done by ExecuteAbstractlyWithOptions.
+// Zero values impose no limit.
+type ExecutionOptions struct {
+	// Maximum number of states to explore
+	MaxStates int
+	// Maximum time to spend exploring
+	Timeout time.Duration
+	// Number of distinct states that may reach a location before further ones
+	// are widened into those already seen there, for states that implement
+	// WideningState
+	WidenAfter int
+}
+
+// TruncatedError is returned when ExecuteAbstractlyWithOptions exhausts its
+// budget before exploring every state.
+type TruncatedError struct {
+	States  int
+	Elapsed time.Duration
+}
+
+func (self *TruncatedError) Error() string {
+	return fmt.Sprintf("Analysis truncated after exploring %v states in %v", self.States, self.Elapsed.Round(time.Microsecond))
+}
+
 func ExecuteAbstractly(initial EvmState) error {
+	return ExecuteAbstractlyWithOptions(initial, ExecutionOptions{})
+}
+
+// ExecuteAbstractlyWithOptions explores every state reachable from initial,
+// within the bounds set by options. If the budget is exhausted, it stops and
+// returns a *TruncatedError.
+func ExecuteAbstractlyWithOptions(initial EvmState, options ExecutionOptions) error {
+	return ExecuteAbstractlyContext(context.Background(), initial, options)
+}
+
+// ExecuteAbstractlyContext is ExecuteAbstractlyWithOptions, stopping early with
+// ctx.Err() if ctx is cancelled. The states explored until then will have
+// recorded their results as usual.
+func ExecuteAbstractlyContext(ctx context.Context, initial EvmState, options ExecutionOptions) error {
+	_, err := executeAbstractly(ctx, initial, options)
+	return err
+}
+
+// executeAbstractly is ExecuteAbstractlyContext, also returning the number of
+// states explored, so that several runs can share a budget.
+func executeAbstractly(ctx context.Context, initial EvmState, options ExecutionOptions) (int, error) {
 	stack := []EvmState{initial}
 	seen := make(map[EvmState]bool)
+	// Number of distinct states reaching each location, and the state the
+	// next one there is widened into
+	visits := make(map[interface{}]int)
+	widened := make(map[interface{}]EvmState)
+	start := time.Now()
+	explored := 0
 
 	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return explored, err
+		}
+		if (options.MaxStates > 0 && explored >= options.MaxStates) || (options.Timeout > 0 && time.Since(start) > options.Timeout) {
+			return explored, &TruncatedError{explored, time.Since(start)}
+		}
 		var state EvmState
 		state, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		explored++
 		nextStates, err := state.Advance()
 		if err != nil {
-			return err
+			return explored, err
 		}
 		for _, nextState := range nextStates {
-			if !seen[nextState] {
-				stack = append(stack, nextState)
-				seen[nextState] = true
+			if seen[nextState] {
+				continue
+			}
+			if widening, ok := nextState.(WideningState); ok && options.WidenAfter > 0 {
+				location := widening.Location()
+				visits[location]++
+				if previous := widened[location]; previous != nil && visits[location] > options.WidenAfter {
+					nextState = previous.(WideningState).Widen(nextState)
+				}
+				widened[location] = nextState
+				if seen[nextState] {
+					continue
+				}
 			}
+			stack = append(stack, nextState)
+			seen[nextState] = true
 		}
 	}
 
-	return nil
+	return explored, nil
 }
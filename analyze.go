@@ -0,0 +1,324 @@
+package evmdis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Arachnid/evmdis/metadata"
+	"github.com/Arachnid/evmdis/signatures"
+)
+
+// Formats lists the output formats Analyze can render.
+var Formats = []string{"text", "dot", "pseudo", "json"}
+
+// Renderers for each output format but JSON, which covers every program at once
+var renderers = map[string]func(*Program) string{
+	"text":   RenderText,
+	"dot":    RenderDot,
+	"pseudo": RenderPseudocode,
+}
+
+// Options configures Analyze.
+type Options struct {
+	// Remove the CBOR encoded metadata compilers append to the bytecode before
+	// analysing it; it's decoded into Result.Metadata either way
+	StripMetadata bool
+	// The bytecode is constructor code followed by the runtime code it
+	// deploys, which are analysed separately
+	Constructor bool
+	// Fork whose opcodes are used to decode the bytecode
+	Fork Fork
+	// Format Result.Output is rendered in, one of Formats; nothing is rendered
+	// if it's empty
+	Format   string
+	Reaching ReachingOptions
+	// Resolver used to name function selectors and event topics, or nil
+	Resolver signatures.Resolver
+	// Replace constant subexpressions with their values
+	FoldConstants bool
+	// Annotate each block with its LiveDefinitions
+	Liveness bool
+}
+
+// DefaultOptions returns the options evmdis uses by default: metadata is
+// stripped, the latest fork's opcodes are used, the output is text, and names
+// come from the built-in signature database.
+func DefaultOptions() Options {
+	return Options{
+		StripMetadata: true,
+		Fork:          LatestFork,
+		Format:        "text",
+		Reaching:      DefaultReachingOptions(),
+		Resolver:      signatures.Builtin(),
+	}
+}
+
+// NamedProgram is one of the programs analysed by Analyze, named as in the JSON
+// output.
+type NamedProgram struct {
+	Name    string
+	Program *Program
+}
+
+// ContainerResult is an EOF container whose code sections have been analysed,
+// along with those of its subcontainers.
+type ContainerResult struct {
+	// Nil if the container couldn't be parsed, in which case Err says why
+	Container *Container
+	Err       error
+	// The raw container
+	Bytecode      []byte
+	Subcontainers []*ContainerResult
+}
+
+// Result holds the analysed programs found in some bytecode.
+type Result struct {
+	// Metadata decoded from the end of legacy bytecode, and a guess at the
+	// compiler that produced it
+	Metadata    *metadata.Metadata
+	Fingerprint *Fingerprint
+	// The code, or the constructor and code in constructor mode, or each code
+	// section of an EOF container and its subcontainers
+	Programs []*NamedProgram
+	// The parsed EOF container, if the bytecode was one
+	Container *ContainerResult
+	// Diagnostics recorded against all of Programs
+	Diagnostics Diagnostics
+	// Rendering of the programs in the requested format
+	Output string
+}
+
+// Analyze decodes and analyses bytecode, legacy or EOF, and renders it in the
+// format given by options. Problems with the code are recorded as diagnostics;
+// an error is only returned if the bytecode can't be analysed at all.
+func Analyze(bytecode []byte, options Options) (*Result, error) {
+	return AnalyzeContext(context.Background(), bytecode, options)
+}
+
+// AnalyzeContext is Analyze, stopping early if ctx is cancelled. It then
+// returns ctx.Err() along with the partial results found until then.
+func AnalyzeContext(ctx context.Context, bytecode []byte, options Options) (*Result, error) {
+	if _, ok := renderers[options.Format]; !ok && options.Format != "json" && options.Format != "" {
+		return nil, fmt.Errorf("Unknown format: %v", options.Format)
+	}
+
+	result := &Result{}
+	if IsEOF(bytecode) {
+		result.Container = analyzeContainer(ctx, "", bytecode, options, result)
+		if result.Container.Err != nil {
+			return nil, result.Container.Err
+		}
+	} else if err := analyzeLegacy(ctx, bytecode, options, result); err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	for _, program := range result.Programs {
+		for _, diagnostic := range program.Program.Diagnostics.List {
+			result.Diagnostics.Add(diagnostic)
+		}
+	}
+
+	switch {
+	case options.Format == "json":
+		document := NewJSONDocument(result.Metadata, result.Fingerprint)
+		for _, program := range result.Programs {
+			document.Programs = append(document.Programs, NewJSONProgram(program.Name, program.Program))
+		}
+		result.Output = document.String()
+	case result.Container != nil:
+		result.Output = fmt.Sprintf("# EOF container version %d\n\n", result.Container.Container.Version)
+		result.Output += renderContainer(result.Container, options.Format)
+	case options.Format != "":
+		result.Output = renderLegacy(result, options.Format)
+	}
+	return result, ctx.Err()
+}
+
+func analyzeLegacy(ctx context.Context, bytecode []byte, options Options, result *Result) error {
+	code, meta := metadata.Split(bytecode)
+	bytecodeLength := uint64(len(bytecode))
+	if options.StripMetadata {
+		bytecodeLength = uint64(len(code))
+	}
+
+	program := NewProgramForFork(bytecode[:bytecodeLength], options.Fork)
+	program.Metadata = meta
+	result.Metadata = meta
+	if err := AnalyzeProgramContext(ctx, program, options); ctx.Err() != nil {
+		result.Programs = append(result.Programs, &NamedProgram{"code", program})
+		return err
+	}
+	result.Fingerprint = FingerprintProgram(program)
+
+	if !options.Constructor {
+		result.Programs = append(result.Programs, &NamedProgram{"code", program})
+		return nil
+	}
+
+	codeEntryPoint := FindNextCodeEntryPoint(program)
+	if codeEntryPoint == 0 {
+		return fmt.Errorf("No code entrypoint found in ctor")
+	} else if codeEntryPoint >= bytecodeLength {
+		return fmt.Errorf("Code entrypoint outside of currently available code")
+	}
+	for _, part := range []*NamedProgram{
+		{"constructor", NewProgramForFork(bytecode[:codeEntryPoint], options.Fork)},
+		{"code", NewProgramForFork(bytecode[codeEntryPoint:bytecodeLength], options.Fork)},
+	} {
+		result.Programs = append(result.Programs, part)
+		if err := AnalyzeProgramContext(ctx, part.Program, options); ctx.Err() != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderLegacy(result *Result, format string) (disassembly string) {
+	render := renderers[format]
+	if result.Metadata != nil {
+		disassembly += fmt.Sprintf("# Metadata: %v\n", result.Metadata)
+	}
+	if result.Fingerprint != nil && result.Fingerprint.Compiler != CompilerUnknown {
+		disassembly += fmt.Sprintf("# Compiler: %v\n", result.Fingerprint)
+	}
+	if len(disassembly) > 0 {
+		disassembly += fmt.Sprintln()
+	}
+
+	if len(result.Programs) == 2 {
+		disassembly += fmt.Sprintln("# Constructor part -------------------------")
+		disassembly += render(result.Programs[0].Program)
+		disassembly += fmt.Sprintln("# Code part -------------------------")
+		disassembly += render(result.Programs[1].Program)
+	} else {
+		for _, program := range result.Programs {
+			disassembly += render(program.Program)
+		}
+	}
+	return disassembly
+}
+
+// analyzeContainer parses and analyses an EOF container and its
+// subcontainers, adding their code sections to the result with names prefixed
+// by prefix.
+func analyzeContainer(ctx context.Context, prefix string, bytecode []byte, options Options, result *Result) *ContainerResult {
+	container, err := ParseContainer(bytecode, options.Fork)
+	ret := &ContainerResult{Container: container, Err: err, Bytecode: bytecode}
+	if err != nil {
+		return ret
+	}
+
+	for i, section := range container.Sections {
+		result.Programs = append(result.Programs, &NamedProgram{fmt.Sprintf("%vsection %d", prefix, i), section})
+		if ctx.Err() == nil {
+			AnalyzeProgramContext(ctx, section, options)
+		}
+	}
+	for i, subcontainer := range container.Subcontainers {
+		ret.Subcontainers = append(ret.Subcontainers, analyzeContainer(ctx, fmt.Sprintf("%vsubcontainer %d/", prefix, i), subcontainer, options, result))
+	}
+	return ret
+}
+
+func renderContainer(result *ContainerResult, format string) (disassembly string) {
+	render := renderers[format]
+	// Data is only shown in text output
+	printData := format == "text"
+
+	container := result.Container
+	for i, section := range container.Sections {
+		functionType := container.Types[i]
+		outputs := fmt.Sprintf("%d outputs", functionType.Outputs)
+		if functionType.NonReturning {
+			outputs = "non-returning"
+		}
+		disassembly += fmt.Sprintf("# Code section %d (%d inputs, %v) -------------------------\n", i, functionType.Inputs, outputs)
+		disassembly += render(section)
+	}
+
+	for i, subcontainer := range result.Subcontainers {
+		disassembly += fmt.Sprintf("# Subcontainer %d -------------------------\n", i)
+		if subcontainer.Err != nil {
+			disassembly += fmt.Sprintf("# Unable to disassemble: %v\n", subcontainer.Err)
+			if printData {
+				disassembly += renderDataRegion(&DataRegion{Data: subcontainer.Bytecode})
+			}
+		} else {
+			disassembly += fmt.Sprintf("# EOF container version %d\n\n", subcontainer.Container.Version)
+			disassembly += renderContainer(subcontainer, format)
+		}
+	}
+
+	if len(container.Data) > 0 && printData {
+		disassembly += renderDataRegion(&DataRegion{Data: container.Data, Reason: DataReasonEOFData})
+	}
+	return disassembly
+}
+
+// AnalyzeProgram runs each analysis over the program. Problems are recorded in
+// the program's Diagnostics rather than aborting the analysis.
+func AnalyzeProgram(program *Program, options Options) {
+	AnalyzeProgramContext(context.Background(), program, options)
+}
+
+// AnalyzeProgramContext is AnalyzeProgram, stopping early with ctx.Err() if ctx
+// is cancelled. The results of the analyses run until then, including the
+// partial results of the one that was interrupted, are left in the program.
+func AnalyzeProgramContext(ctx context.Context, program *Program, options Options) error {
+	if err := PerformReachingAnalysisContext(ctx, program, options.Reaching); err != nil {
+		program.Diagnostics.Errorf(AnalysisReaching, nil, -1, "%v", err)
+		return err
+	}
+	if err := PerformValueAnalysisContext(ctx, program, options.Reaching.Execution); err != nil {
+		program.Diagnostics.Errorf(AnalysisValues, nil, -1, "%v", err)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	PerformDataAnalysis(program)
+	PerformReachesAnalysis(program)
+	if options.Liveness {
+		PerformLivenessAnalysis(program)
+	}
+	PerformDominatorAnalysis(program)
+	PerformInternalFunctionAnalysis(program)
+	PerformLoopAnalysis(program)
+	PerformDispatcherAnalysis(program)
+	if options.Resolver != nil {
+		PerformSignatureAnalysis(program, options.Resolver)
+	}
+	CreateLabels(program)
+	if err := BuildExpressionsContext(ctx, program); err != nil {
+		program.Diagnostics.Errorf(AnalysisExpressions, nil, -1, "%v", err)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	if options.FoldConstants {
+		FoldConstants(program)
+	}
+	return nil
+}
+
+// FindNextCodeEntryPoint returns the offset of the code a constructor copies
+// out to deploy, taken from the last CODECOPY it executes, or 0 if there isn't
+// one. The program must already have been analysed.
+func FindNextCodeEntryPoint(program *Program) uint64 {
+	var lastPos uint64 = 0
+	for _, block := range program.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction.Op == CODECOPY {
+				var expression Expression
+
+				instruction.Annotations.Get(&expression)
+
+				// Skip copies that weren't reached by the analysis
+				if expression, ok := expression.(*InstructionExpression); ok {
+					if arg := expression.Arguments[1].Eval(); arg != nil {
+						lastPos = arg.Uint64()
+					}
+				}
+			}
+		}
+	}
+	return lastPos
+}
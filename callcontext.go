@@ -0,0 +1,124 @@
+package evmdis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Arachnid/evmdis/stack"
+)
+
+// CallContext identifies the calling context a block was reached in: the
+// return addresses on the stack, innermost first, up to the context depth of
+// the reaching analysis. Return addresses are identified by the instructions
+// that pushed them, so that calls returning to the same block are told apart.
+type CallContext []InstructionPointer
+
+func (self CallContext) String() string {
+	addresses := make([]string, 0, len(self))
+	for _, ptr := range self {
+		addresses = append(addresses, fmt.Sprintf("%v@0x%X", ptr, ptr.GetAddress()))
+	}
+	return fmt.Sprintf("[%v]", strings.Join(addresses, " "))
+}
+
+func (self CallContext) Equals(other CallContext) bool {
+	if len(self) != len(other) {
+		return false
+	}
+	for i := range self {
+		if self[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ContextReaching is the reaching definition of a block or instruction in one
+// calling context.
+type ContextReaching struct {
+	Context  CallContext
+	Reaching ReachingDefinition
+}
+
+// ContextReachingDefinition annotates blocks and instructions with their
+// reaching definitions in each context they were reached in, in the order the
+// contexts were first seen.
+type ContextReachingDefinition []*ContextReaching
+
+// For returns the reaching definition in a context, or nil if it wasn't
+// reached in that context.
+func (self ContextReachingDefinition) For(context CallContext) ReachingDefinition {
+	for _, reaching := range self {
+		if reaching.Context.Equals(context) {
+			return reaching.Reaching
+		}
+	}
+	return nil
+}
+
+// contextDefinitions returns the definitions of an instruction's argument in
+// the contexts it was reached in, ordered by address, if there is more than
+// one context and exactly one definition in each. Otherwise it returns nil.
+func contextDefinitions(inst *Instruction, argument int) []InstructionPointer {
+	var contexts ContextReachingDefinition
+	inst.Annotations.Get(&contexts)
+	if len(contexts) < 2 {
+		return nil
+	}
+	definitions := make(InstructionPointerSet)
+	for _, context := range contexts {
+		if len(context.Reaching) <= argument || len(context.Reaching[argument]) != 1 {
+			return nil
+		}
+		definitions[*context.Reaching[argument].First()] = true
+	}
+	return definitions.Sorted()
+}
+
+// callContext returns the calling context of the state: the innermost return
+// addresses on its stack, which are taken to be any pushed JUMPDEST offset.
+func (self reachingState) callContext() CallContext {
+	var context CallContext
+	for frame := self.stack; frame.Height() > 0 && len(context) < self.contextDepth; frame = frame.Up() {
+		// Slots joined by widening can't be a single return address
+		ptr, ok := frame.Value().(InstructionPointer)
+		if !ok {
+			continue
+		}
+		inst := ptr.Get()
+		if !inst.Op.IsPush() || !inst.Arg.IsInt64() {
+			continue
+		}
+		if _, ok := self.program.JumpDestinations[int(inst.Arg.Int64())]; ok {
+			context = append(context, ptr)
+		}
+	}
+	return context
+}
+
+// updateContext merges the definitions reaching a block or instruction in a
+// context into its annotations.
+func updateContext(annotations *TypeMap, context CallContext, merge func(ReachingDefinition) ReachingDefinition) {
+	var contexts ContextReachingDefinition
+	annotations.Get(&contexts)
+	for _, reaching := range contexts {
+		if reaching.Context.Equals(context) {
+			reaching.Reaching = merge(reaching.Reaching)
+			return
+		}
+	}
+	contexts = append(contexts, &ContextReaching{context, merge(nil)})
+	annotations.Set(&contexts)
+}
+
+func updateBlockContextReachings(block *BasicBlock, context CallContext, stack stack.StackFrame) {
+	updateContext(block.Annotations, context, func(reachings ReachingDefinition) ReachingDefinition {
+		return mergeBlockReachings(reachings, stack)
+	})
+}
+
+func updateContextReachings(inst *Instruction, context CallContext, operands []interface{}) {
+	updateContext(inst.Annotations, context, func(reachings ReachingDefinition) ReachingDefinition {
+		return mergeReachings(reachings, operands)
+	})
+}
@@ -0,0 +1,77 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"testing"
+)
+
+// A helper at 0x12 that doubles its argument, called from 0x6 returning to
+// 0x7 and from 0xF returning to 0x10
+const sharedHelperCode = "600760026012565b50601060036012565b005b6002029056"
+
+func analyzeHex(t *testing.T, code string, options Options) *Program {
+	bytecode, err := hex.DecodeString(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	prog := NewProgram(bytecode)
+	options.Resolver = nil
+	AnalyzeProgram(prog, options)
+	return prog
+}
+
+func TestContextReturnJump(t *testing.T) {
+	for _, test := range []struct {
+		depth      int
+		contexts   int
+		expression string
+	}{
+		{0, 0, "JUMP(POP())"},
+		{1, 2, "JUMP(POP(:label0 | :label1))"},
+		{2, 2, "JUMP(POP(:label0 | :label1))"},
+	} {
+		options := DefaultOptions()
+		options.Reaching.ContextDepth = test.depth
+		prog := analyzeHex(t, sharedHelperCode, options)
+
+		helper := prog.JumpDestinations[0x12]
+		ret := &helper.Instructions[len(helper.Instructions)-1]
+		var contexts ContextReachingDefinition
+		ret.Annotations.Get(&contexts)
+		if len(contexts) != test.contexts {
+			t.Errorf("depth %d: got %d contexts, want %d", test.depth, len(contexts), test.contexts)
+		}
+		returnsTo := make(map[InstructionPointer]bool)
+		for _, context := range contexts {
+			if len(context.Reaching[0]) != 1 {
+				t.Errorf("depth %d: return address in context %v is %v, want a single definition", test.depth, context.Context, context.Reaching[0])
+				continue
+			}
+			returnsTo[*context.Reaching[0].First()] = true
+		}
+		if len(returnsTo) != test.contexts {
+			t.Errorf("depth %d: contexts return to %d addresses, want %d", test.depth, len(returnsTo), test.contexts)
+		}
+
+		successors := make(map[*BasicBlock]bool)
+		for _, edge := range helper.Successors {
+			successors[edge.To] = true
+		}
+		if len(successors) != 2 || !successors[prog.JumpDestinations[0x7]] || !successors[prog.JumpDestinations[0x10]] {
+			t.Errorf("depth %d: return jump has successors %v, want the blocks at 0x7 and 0x10", test.depth, helper.Successors)
+		}
+
+		var expression Expression
+		ret.Annotations.Get(&expression)
+		if expression == nil || expression.String() != test.expression {
+			t.Errorf("depth %d: return jump is %v, want %v", test.depth, expression, test.expression)
+		}
+
+		if len(prog.InternalFunctions) != 1 || len(prog.InternalFunctions[0].Calls) != 2 {
+			t.Errorf("depth %d: got functions %v, want one called twice", test.depth, prog.InternalFunctions)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package evmdis
+
+type EdgeKind int
+
+const (
+	// Execution continues into the next block without a jump
+	EdgeFallthrough EdgeKind = iota
+	// An unconditional jump to a known target
+	EdgeJump
+	// The taken and not taken branches of a conditional jump
+	EdgeConditionalTrue
+	EdgeConditionalFalse
+	// A jump whose target couldn't be determined, conservatively assumed to
+	// reach every JUMPDEST
+	EdgeUnresolved
+)
+
+var edgeKindToString = map[EdgeKind]string{
+	EdgeFallthrough:      "fallthrough",
+	EdgeJump:             "jump",
+	EdgeConditionalTrue:  "true",
+	EdgeConditionalFalse: "false",
+	EdgeUnresolved:       "unresolved",
+}
+
+func (self EdgeKind) String() string {
+	return edgeKindToString[self]
+}
+
+// Edge is a control flow edge between two basic blocks.
+type Edge struct {
+	From *BasicBlock
+	To   *BasicBlock
+	Kind EdgeKind
+}
+
+// addEdge records an edge from one block to another, if it isn't already
+// present.
+func addEdge(from, to *BasicBlock, kind EdgeKind) {
+	for _, edge := range from.Successors {
+		if edge.To == to && edge.Kind == kind {
+			return
+		}
+	}
+	edge := &Edge{from, to, kind}
+	from.Successors = append(from.Successors, edge)
+	to.Predecessors = append(to.Predecessors, edge)
+}
+
+// resetEdges discards the control flow graph of a program, so it can be rebuilt.
+func (self *Program) resetEdges() {
+	for _, block := range self.Blocks {
+		block.Successors = nil
+		block.Predecessors = nil
+	}
+	self.Entry = nil
+	self.Exits = nil
+}
+
+// findExits sets Exits to the reachable blocks that have no successors.
+func (self *Program) findExits() {
+	for _, block := range self.Blocks {
+		var reaching ReachingDefinition
+		block.Annotations.Get(&reaching)
+		if reaching != nil && len(block.Successors) == 0 {
+			self.Exits = append(self.Exits, block)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package evmdis
+
+import (
+	"testing"
+)
+
+func TestControlFlowGraph(t *testing.T) {
+	// CALLVALUE PUSH1 0x9 JUMPI | PUSH1 0x1 POP | 0x7: JUMPDEST STOP |
+	// 0x9: JUMPDEST PUSH1 0x0 CALLDATALOAD JUMP
+	prog := analyzeHex(t, "346009576001505b005b60003556", DefaultOptions())
+	entry, next := prog.Entry, prog.Entry.Next
+	stop, jump := prog.JumpDestinations[0x7], prog.JumpDestinations[0x9]
+
+	type edge struct {
+		from, to *BasicBlock
+		kind     EdgeKind
+	}
+	want := map[edge]bool{
+		{entry, jump, EdgeConditionalTrue}:  true,
+		{entry, next, EdgeConditionalFalse}: true,
+		{next, stop, EdgeFallthrough}:       true,
+		// A jump to calldata may go to any JUMPDEST
+		{jump, stop, EdgeUnresolved}: true,
+		{jump, jump, EdgeUnresolved}: true,
+	}
+	// Each edge is both a successor and a predecessor
+	got := make(map[edge]bool)
+	predecessors := make(map[edge]bool)
+	for _, block := range prog.Blocks {
+		for _, e := range block.Successors {
+			if e.From == block {
+				got[edge{e.From, e.To, e.Kind}] = true
+			}
+		}
+		for _, e := range block.Predecessors {
+			if e.To == block {
+				predecessors[edge{e.From, e.To, e.Kind}] = true
+			}
+		}
+	}
+	if len(predecessors) != len(got) {
+		t.Errorf("got %d edges as successors and %d as predecessors", len(got), len(predecessors))
+	}
+	for e := range want {
+		if !got[e] {
+			t.Errorf("missing %v edge from 0x%X to 0x%X", e.kind, e.from.Offset, e.to.Offset)
+		}
+	}
+	for e := range got {
+		if !want[e] || !predecessors[e] {
+			t.Errorf("unexpected or one-sided %v edge from 0x%X to 0x%X", e.kind, e.from.Offset, e.to.Offset)
+		}
+	}
+	if len(prog.Exits) != 1 || prog.Exits[0] != stop {
+		t.Errorf("got exits %v, want the STOP", prog.Exits)
+	}
+}
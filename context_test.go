@@ -0,0 +1,40 @@
+package evmdis
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bytecode, _ := hex.DecodeString(sharedHelperCode)
+	prog := NewProgram(bytecode)
+	if err := AnalyzeProgramContext(ctx, prog, DefaultOptions()); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	// The blocks parsed before analysis are left in place, and the
+	// interruption is recorded against the pass that was running
+	if len(prog.Blocks) == 0 || !prog.Diagnostics.HasErrors() {
+		t.Errorf("got %d blocks and diagnostics %v", len(prog.Blocks), prog.Diagnostics.List)
+	}
+	for _, diagnostic := range prog.Diagnostics.List {
+		if diagnostic.Analysis != AnalysisReaching || !strings.Contains(diagnostic.Message, "canceled") {
+			t.Errorf("unexpected diagnostic %v", diagnostic)
+		}
+	}
+
+	options := DefaultOptions()
+	options.Resolver = nil
+	options.Format = "text"
+	result, err := AnalyzeContext(ctx, bytecode, options)
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if result == nil || len(result.Programs) != 1 || !result.Diagnostics.HasErrors() || result.Output == "" {
+		t.Fatalf("got result %+v, want the partial results", result)
+	}
+}
@@ -3,24 +3,33 @@ package evmdis
 import (
 	"fmt"
 	"math/big"
-)
 
-/*type Analysis interface {
-    func Process(*Instruction) Analysis
-    func Combine(Analysis) Analysis
-    func Copy() Analysis
-    func Equals(Analysis) bool
-}*/
+	"github.com/Arachnid/evmdis/metadata"
+)
 
 type Instruction struct {
-	Op          OpCode
-	Arg         *big.Int
+	Op  OpCode
+	Arg *big.Int
+	// Set if the instruction's byte isn't a defined opcode in the program's
+	// fork. Op is then INVALID, which the EVM treats it as, and Arg holds the
+	// byte.
+	Undefined   bool
+	Immediate   []byte
 	Annotations *TypeMap
-	//analyses map[reflect.Type]Analysis
+}
+
+// Size returns the number of bytes the instruction occupies in the bytecode.
+func (self *Instruction) Size() int {
+	if self.Immediate != nil {
+		return len(self.Immediate) + 1
+	}
+	return self.Op.OperandSize() + 1
 }
 
 func (self *Instruction) String() string {
-	if self.Arg != nil {
+	if self.Undefined {
+		return fmt.Sprintf("UNDEFINED(0x%02X)", self.Arg)
+	} else if self.Arg != nil {
 		return fmt.Sprintf("%v 0x%x", self.Op, self.Arg)
 	} else {
 		return self.Op.String()
@@ -31,6 +40,12 @@ type BasicBlock struct {
 	Instructions []Instruction
 	Offset       int
 	Next         *BasicBlock
+	// Targets of a static jump (RJUMP, RJUMPI or RJUMPV) ending the block
+	StaticTargets []*BasicBlock
+	// Control flow edges into and out of the block, found by the reaching
+	// analysis
+	Successors   []*Edge
+	Predecessors []*Edge
 	Annotations  *TypeMap
 }
 
@@ -40,21 +55,82 @@ func (bb *BasicBlock) OffsetOf(inst *Instruction) int {
         if inst == &bb.Instructions[i] {
             return offset
         }
-        offset += bb.Instructions[i].Op.OperandSize() + 1
+        offset += bb.Instructions[i].Size()
     }
     return -1
 }
 
+func (bb *BasicBlock) End() int {
+	offset := bb.Offset
+	for _, inst := range bb.Instructions {
+		offset += inst.Size()
+	}
+	return offset
+}
+
+// FallsThrough returns true if execution can continue from the end of the block
+// into the next one.
+func (bb *BasicBlock) FallsThrough() bool {
+	if len(bb.Instructions) == 0 {
+		return true
+	}
+	op := bb.Instructions[len(bb.Instructions)-1].Op
+	return op != JUMP && op != RJUMP && op != RETF && op != JUMPF && !op.Halts()
+}
+
 type Program struct {
 	Blocks           []*BasicBlock
 	JumpDestinations map[int]*BasicBlock
+	DataRegions      []*DataRegion
+	Metadata         *metadata.Metadata
+	Fork             Fork
+	// For code sections of EOF containers, the container and index of the
+	// section, and a synthetic block defining the section's inputs
+	Container *Container
+	Section   int
+	Inputs    *BasicBlock
+	// Problems found by the analyses run over the program
+	Diagnostics Diagnostics
+	// The block execution starts at, and the reachable blocks it can end in,
+	// found by the reaching analysis
+	Entry *BasicBlock
+	Exits []*BasicBlock
+	// The contract's function dispatcher, if one was recognised
+	Dispatcher *Dispatcher
+	// Internal functions found from their calls, ordered by entry offset
+	InternalFunctions []*InternalFunction
+	// The bytecode the program was decoded from, and the length of the code
+	// in it before any metadata trailer, from which data regions are taken
+	bytecode   []byte
+	codeLength int
+	// Set if the reaching analysis stopped before following every path, so
+	// that blocks it didn't reach may still be reachable
+	incomplete bool
 	//Instructions map[int]*Instruction
 }
 
 func NewProgram(bytecode []byte) *Program {
+	return NewProgramForFork(bytecode, LatestFork)
+}
+
+// NewProgramForFork decodes bytecode using the opcodes defined in the given
+// fork; bytes that are not valid opcodes in that fork are decoded as Undefined
+// instructions, which end their basic block. Trailing data, such as compiler
+// metadata or code copied out by the constructor, is split off into
+// DataRegions rather than being decoded as instructions.
+func NewProgramForFork(bytecode []byte, fork Fork) *Program {
+	code, meta := metadata.Split(bytecode)
+	program := decodeProgram(code, fork)
+	program.Metadata = meta
+	program.splitDataRegions(bytecode, len(code))
+	return program
+}
+
+func decodeProgram(bytecode []byte, fork Fork) *Program {
 	bytecodeLength := len(bytecode)
 	program := &Program{
 		JumpDestinations: make(map[int]*BasicBlock),
+		Fork:             fork,
 	}
 
 	currentBlock := &BasicBlock{
@@ -66,7 +142,12 @@ func NewProgram(bytecode []byte) *Program {
 		op := OpCode(bytecode[i])
 		size := op.OperandSize()
 		var arg *big.Int
-		if size > 0 {
+		undefined := !op.IsAvailableIn(fork)
+		if undefined {
+			arg = big.NewInt(int64(op))
+			op = INVALID
+			size = 0
+		} else if op.IsPush() {
 			arg = big.NewInt(0)
 			for j := 1; j <= size; j++ {
 				arg.Lsh(arg, 8)
@@ -92,11 +173,12 @@ func NewProgram(bytecode []byte) *Program {
 			instruction := Instruction{
 				Op:          op,
 				Arg:         arg,
+				Undefined:   undefined,
 				Annotations: NewTypeMap(),
 			}
 			currentBlock.Instructions = append(currentBlock.Instructions, instruction)
 
-			if op.IsJump() || op == RETURN || op == SELFDESTRUCT || op == STOP || op == INVALID || op == REVERT {
+			if op.IsJump() || op.Halts() {
 				program.Blocks = append(program.Blocks, currentBlock)
 				newBlock := &BasicBlock{
 					Offset:      i + size + 1,
@@ -111,7 +193,7 @@ func NewProgram(bytecode []byte) *Program {
 
 	if len(currentBlock.Instructions) > 0 || program.JumpDestinations[currentBlock.Offset] != nil {
 		program.Blocks = append(program.Blocks, currentBlock)
-	} else {
+	} else if len(program.Blocks) > 0 {
 		program.Blocks[len(program.Blocks)-1].Next = nil
 	}
 
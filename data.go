@@ -0,0 +1,301 @@
+package evmdis
+
+import (
+	"math/big"
+	"sort"
+)
+
+const (
+	DataReasonMetadata    = "metadata"
+	DataReasonUnreachable = "unreachable after terminator"
+	DataReasonCodeCopy    = "CODECOPY source"
+	DataReasonEOFData     = "EOF data section"
+)
+
+// DataRegion is a range of the bytecode that has been identified as data rather
+// than code, along with the reason it was identified as such.
+type DataRegion struct {
+	Offset int
+	Data   []byte
+	Reason string
+}
+
+func (self *DataRegion) End() int {
+	return self.Offset + len(self.Data)
+}
+
+// codeCopy is the range [start, end) of the bytecode copied by the CODECOPY at
+// pc; end is -1 if the size of the copy isn't constant.
+type codeCopy struct {
+	pc         int
+	start, end int
+}
+
+// splitDataRegions removes the blocks at the end of the program that can't be
+// reached, and records them and any metadata trailer as data regions.
+// codeLength is the length of the code the program was decoded from.
+func (self *Program) splitDataRegions(bytecode []byte, codeLength int) {
+	self.bytecode = bytecode
+	self.codeLength = codeLength
+	if len(self.Blocks) == 0 {
+		self.recordDataRegions(0, nil)
+		return
+	}
+	sources := self.findCodeCopySources()
+	self.recordDataRegions(self.truncateAfter(self.lastReachable(sources)), sources)
+}
+
+// recordDataRegions records the code from codeEnd onwards as data, split on
+// the offsets it's copied from, followed by any metadata trailer.
+func (self *Program) recordDataRegions(codeEnd int, sources []codeCopy) {
+	codeLength := self.codeLength
+	self.DataRegions = nil
+	if codeEnd < codeLength {
+		// Split the trailing data on any constant offsets it's copied from
+		boundaries := map[int]bool{codeEnd: true, codeLength: true}
+		copied := make(map[int]bool)
+		for _, source := range sources {
+			if source.start < codeEnd || source.start >= codeLength {
+				continue
+			}
+			boundaries[source.start] = true
+			copied[source.start] = true
+			if source.end > source.start && source.end < codeLength {
+				boundaries[source.end] = true
+			}
+		}
+
+		offsets := make([]int, 0, len(boundaries))
+		for offset := range boundaries {
+			offsets = append(offsets, offset)
+		}
+		sort.Ints(offsets)
+
+		for i := 0; i < len(offsets)-1; i++ {
+			reason := DataReasonUnreachable
+			if copied[offsets[i]] {
+				reason = DataReasonCodeCopy
+			}
+			self.DataRegions = append(self.DataRegions, &DataRegion{
+				Offset: offsets[i],
+				Data:   self.bytecode[offsets[i]:offsets[i+1]],
+				Reason: reason,
+			})
+		}
+	}
+
+	if codeLength < len(self.bytecode) {
+		self.DataRegions = append(self.DataRegions, &DataRegion{
+			Offset: codeLength,
+			Data:   self.bytecode[codeLength:],
+			Reason: DataReasonMetadata,
+		})
+	}
+}
+
+// lastReachable returns the index of the last block that can be reached
+// either by falling through from the entry point or by a jump to a JUMPDEST
+// pushed by reachable code. Code copied out by a CODECOPY later in the
+// program is data to this code, so JUMPDESTs inside it aren't counted.
+func (self *Program) lastReachable(sources []codeCopy) int {
+	copied := func(block *BasicBlock) bool {
+		start := block.Offset
+		if self.JumpDestinations[block.Offset-1] == block {
+			start--
+		}
+		for _, source := range sources {
+			end := source.end
+			if end < 0 {
+				end = self.codeLength
+			}
+			if source.start > source.pc && start >= source.start && start < end {
+				return true
+			}
+		}
+		return false
+	}
+	reachable := map[*BasicBlock]bool{self.Blocks[0]: true}
+	reach := func(block *BasicBlock) bool {
+		if block == nil || reachable[block] || copied(block) {
+			return false
+		}
+		reachable[block] = true
+		return true
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, block := range self.Blocks {
+			if !reachable[block] {
+				continue
+			}
+
+			if i+1 < len(self.Blocks) && block.FallsThrough() && reach(self.Blocks[i+1]) {
+				changed = true
+			}
+
+			// A jump to a computed target may reach any JUMPDEST
+			if n := len(block.Instructions); n > 0 && block.Instructions[n-1].Op.IsJump() && (n == 1 || !block.Instructions[n-2].Op.IsPush()) {
+				for _, dest := range self.JumpDestinations {
+					changed = reach(dest) || changed
+				}
+			}
+
+			for _, inst := range block.Instructions {
+				if inst.Op.IsPush() && inst.Arg.IsInt64() && reach(self.JumpDestinations[int(inst.Arg.Int64())]) {
+					changed = true
+				}
+			}
+		}
+	}
+
+	last := len(self.Blocks) - 1
+	for !reachable[self.Blocks[last]] {
+		last--
+	}
+	return last
+}
+
+// truncateAfter removes the blocks after the one at index last, and returns
+// the new end of the code.
+func (self *Program) truncateAfter(last int) int {
+	removed := make(map[*BasicBlock]bool)
+	for _, block := range self.Blocks[last+1:] {
+		removed[block] = true
+	}
+	self.Blocks[last].Next = nil
+	self.Blocks = self.Blocks[:last+1]
+
+	codeEnd := self.Blocks[last].End()
+	for offset := range self.JumpDestinations {
+		if offset >= codeEnd {
+			delete(self.JumpDestinations, offset)
+		}
+	}
+	self.Diagnostics.Filter(func(diagnostic *Diagnostic) bool {
+		return !removed[diagnostic.Block]
+	})
+	return codeEnd
+}
+
+// PerformDataAnalysis moves the blocks at the end of the program that the
+// reaching and value analyses found to be unreachable into DataRegions, split
+// on the constant ranges copied by CODECOPY. Unlike the split made when the
+// program is decoded, this doesn't have to assume that computed jumps may
+// reach any JUMPDEST. Nothing is moved if the reaching analysis stopped early,
+// as the blocks it didn't reach haven't been shown to be unreachable.
+func PerformDataAnalysis(prog *Program) {
+	if prog.bytecode == nil || len(prog.Blocks) == 0 || prog.incomplete {
+		return
+	}
+	last := len(prog.Blocks) - 1
+	for ; last >= 0; last-- {
+		var reaching ReachingDefinition
+		prog.Blocks[last].Annotations.Get(&reaching)
+		if reaching != nil {
+			break
+		}
+	}
+	if last < 0 || last == len(prog.Blocks)-1 {
+		return
+	}
+	sources := append(prog.findCodeCopySources(), prog.foldCodeCopySources()...)
+	prog.recordDataRegions(prog.truncateAfter(last), sources)
+}
+
+// foldCodeCopySources returns the ranges copied by reachable CODECOPY
+// instructions whose offset folds to a single constant.
+func (self *Program) foldCodeCopySources() []codeCopy {
+	var sources []codeCopy
+	for _, block := range self.Blocks {
+		for i := range block.Instructions {
+			inst := &block.Instructions[i]
+			var reaching ReachingDefinition
+			inst.Annotations.Get(&reaching)
+			if inst.Op != CODECOPY || len(reaching) != 3 {
+				continue
+			}
+			offset, size := foldOperand(reaching[1]), foldOperand(reaching[2])
+			if offset == nil || !offset.IsInt64() {
+				continue
+			}
+			source := codeCopy{block.OffsetOf(inst), int(offset.Int64()), -1}
+			if size != nil && size.IsInt64() {
+				source.end = source.start + int(size.Int64())
+			}
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// foldOperand returns the value of an operand if all the definitions reaching
+// it fold to the same constant, or nil otherwise.
+func foldOperand(pointers InstructionPointerSet) *big.Int {
+	var ret *big.Int
+	for pointer := range pointers {
+		values := foldConstant(pointer, 0)
+		if len(values) != 1 || (ret != nil && ret.Cmp(values[0]) != 0) {
+			return nil
+		}
+		ret = values[0]
+	}
+	return ret
+}
+
+// findCodeCopySources returns the ranges of code copied by CODECOPY
+// instructions whose offset is a constant within their block.
+func (self *Program) findCodeCopySources() []codeCopy {
+	var sources []codeCopy
+	for _, block := range self.Blocks {
+		// Track constant values through pushes, dups and swaps
+		var values []*big.Int
+		peek := func(n int) *big.Int {
+			if n >= len(values) {
+				return nil
+			}
+			return values[len(values)-1-n]
+		}
+
+		offset := block.Offset
+		for _, inst := range block.Instructions {
+			pc := offset
+			offset += inst.Size()
+			op := inst.Op
+			switch {
+			case op == CODECOPY:
+				start, size := peek(1), peek(2)
+				if start != nil && start.IsInt64() {
+					source := codeCopy{pc, int(start.Int64()), -1}
+					if size != nil && size.IsInt64() {
+						source.end = source.start + int(size.Int64())
+					}
+					sources = append(sources, source)
+				}
+			case op.IsDup():
+				values = append(values, peek(op.StackReads()-1))
+				continue
+			case op.IsSwap():
+				n := op.StackReads() - 1
+				if n < len(values) {
+					values[len(values)-1], values[len(values)-1-n] = values[len(values)-1-n], values[len(values)-1]
+				} else if len(values) > 0 {
+					values[len(values)-1] = nil
+				}
+				continue
+			}
+
+			if reads := op.StackReads(); reads < len(values) {
+				values = values[:len(values)-reads]
+			} else {
+				values = values[:0]
+			}
+			for i := 0; i < op.StackWrites(); i++ {
+				values = append(values, nil)
+			}
+			if op.IsPush() {
+				values[len(values)-1] = inst.Arg
+			}
+		}
+	}
+	return sources
+}
@@ -0,0 +1,28 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPerformDataAnalysis(t *testing.T) {
+	// PUSH1 0x5 PUSH1 0x1 ADD JUMP jumps to the JUMPDEST at 0x6; the one at
+	// 0x8 is kept on decoding, as the jump's target isn't known then
+	code := "6005600101565b005b00"
+	bytecode, _ := hex.DecodeString(code)
+	decoded := len(NewProgram(bytecode).Blocks)
+
+	prog := analyzeHex(t, code, DefaultOptions())
+	if len(prog.Blocks) != decoded-1 || len(prog.DataRegions) != 1 || prog.DataRegions[0].Offset != 0x8 || prog.DataRegions[0].Reason != DataReasonUnreachable {
+		t.Errorf("got %d blocks and data regions %v, want the block at 0x8 moved to data", len(prog.Blocks), prog.DataRegions)
+	}
+
+	// A truncated analysis doesn't reach the JUMPDEST at 0x6, but that
+	// doesn't make it data
+	options := DefaultOptions()
+	options.Reaching.Execution.MaxStates = 1
+	prog = analyzeHex(t, code, options)
+	if len(prog.Blocks) != decoded || len(prog.DataRegions) != 0 {
+		t.Errorf("got %d blocks and data regions %v after truncation, want %d blocks and no data", len(prog.Blocks), prog.DataRegions, decoded)
+	}
+}
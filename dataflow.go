@@ -0,0 +1,177 @@
+package evmdis
+
+// Analysis is an element of the lattice a dataflow analysis computes: the state
+// of the analysis at one point in a program. Process and Combine may modify the
+// receiver and return it; the engine copies states before calling them.
+type Analysis interface {
+	// Process returns the state after the instruction at ptr executes, given
+	// the state before it; for a backward analysis, the state before it given
+	// the state after it.
+	Process(ptr InstructionPointer) Analysis
+	// Combine returns the join of two states, where control flow merges.
+	Combine(other Analysis) Analysis
+	Copy() Analysis
+	Equals(other Analysis) bool
+}
+
+// WideningAnalysis is implemented by analyses whose lattice has infinite
+// ascending chains, such as intervals, so that loops still reach a fixpoint.
+type WideningAnalysis interface {
+	Analysis
+	// Widen returns a state at least as large as the join of the receiver, the
+	// previous state at a point, and next, the state flowing into it.
+	Widen(next Analysis) Analysis
+}
+
+type Direction int
+
+const (
+	// States flow from the entry along control flow edges
+	Forward Direction = iota
+	// States flow against control flow edges, towards the entry
+	Backward
+)
+
+type DataflowOptions struct {
+	Direction Direction
+	// Number of times the state at a block may change before it's widened
+	// rather than joined, if the analysis is a WideningAnalysis. As for
+	// ExecutionOptions, zero never widens.
+	WidenAfter int
+}
+
+// DataflowResult holds the states a dataflow analysis reached a fixpoint with.
+// In a forward analysis, blocks the analysis never reached, such as
+// unreachable code, have no state.
+type DataflowResult struct {
+	Direction Direction
+	// States before the first instruction of each block and after its last
+	In  map[*BasicBlock]Analysis
+	Out map[*BasicBlock]Analysis
+}
+
+// Before returns the state before the instruction at ptr executes, or nil if
+// it wasn't reached.
+func (self *DataflowResult) Before(ptr InstructionPointer) Analysis {
+	return self.At(ptr.OriginBlock, ptr.OriginIndex)
+}
+
+// After returns the state after the instruction at ptr executes, or nil if it
+// wasn't reached.
+func (self *DataflowResult) After(ptr InstructionPointer) Analysis {
+	return self.At(ptr.OriginBlock, ptr.OriginIndex+1)
+}
+
+// At returns the state before the instruction at index in block, or after the
+// block if index is the number of instructions in it, by processing the
+// instructions between it and the nearest end of the block the analysis
+// computed a state for.
+func (self *DataflowResult) At(block *BasicBlock, index int) Analysis {
+	if self.Direction == Forward {
+		state := self.In[block]
+		if state == nil {
+			return nil
+		}
+		state = state.Copy()
+		for i := 0; i < index; i++ {
+			state = state.Process(InstructionPointer{block, i})
+		}
+		return state
+	}
+
+	state := self.Out[block]
+	if state == nil {
+		return nil
+	}
+	state = state.Copy()
+	for i := len(block.Instructions) - 1; i >= index; i-- {
+		state = state.Process(InstructionPointer{block, i})
+	}
+	return state
+}
+
+// PerformDataflowAnalysis computes the fixpoint of a monotone dataflow analysis
+// over the control flow graph, which must already have been built by reaching
+// analysis. A forward analysis starts with initial on entry to the program's
+// entry block and propagates states along edges. A backward analysis starts
+// with initial after every block, not only the program's exits, so that
+// blocks in loops that never exit are analysed too, and propagates states
+// against edges; initial must then be the least state of the lattice. States
+// are joined with Combine where control flow merges, and blocks are revisited
+// until no state changes.
+func PerformDataflowAnalysis(prog *Program, initial Analysis, options DataflowOptions) *DataflowResult {
+	result := &DataflowResult{
+		Direction: options.Direction,
+		In:        make(map[*BasicBlock]Analysis),
+		Out:       make(map[*BasicBlock]Analysis),
+	}
+
+	// The states each block starts and ends with in the analysis's direction
+	start, end := result.In, result.Out
+	var roots []*BasicBlock
+	next := successorBlocks
+	if options.Direction == Forward {
+		if prog.Entry != nil {
+			roots = []*BasicBlock{prog.Entry}
+		}
+	} else {
+		start, end = result.Out, result.In
+		// Later blocks first, as their states flow to earlier ones
+		for i := len(prog.Blocks) - 1; i >= 0; i-- {
+			roots = append(roots, prog.Blocks[i])
+		}
+		next = predecessorBlocks
+	}
+
+	var worklist []*BasicBlock
+	queued := make(map[*BasicBlock]bool)
+	changes := make(map[*BasicBlock]int)
+	for _, block := range roots {
+		start[block] = initial.Copy()
+		worklist = append(worklist, block)
+		queued[block] = true
+	}
+
+	for len(worklist) > 0 {
+		block := worklist[0]
+		worklist = worklist[1:]
+		queued[block] = false
+
+		state := start[block].Copy()
+		if options.Direction == Forward {
+			for i := range block.Instructions {
+				state = state.Process(InstructionPointer{block, i})
+			}
+		} else {
+			for i := len(block.Instructions) - 1; i >= 0; i-- {
+				state = state.Process(InstructionPointer{block, i})
+			}
+		}
+		end[block] = state
+
+		for _, target := range next(block) {
+			previous := start[target]
+			var updated Analysis
+			if previous == nil {
+				updated = state.Copy()
+			} else {
+				widening, ok := previous.(WideningAnalysis)
+				if ok && options.WidenAfter > 0 && changes[target] >= options.WidenAfter {
+					updated = widening.Copy().(WideningAnalysis).Widen(state)
+				} else {
+					updated = previous.Copy().Combine(state)
+				}
+				if updated.Equals(previous) {
+					continue
+				}
+				changes[target]++
+			}
+			start[target] = updated
+			if !queued[target] {
+				worklist = append(worklist, target)
+				queued[target] = true
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package evmdis
+
+import (
+	"testing"
+)
+
+// instructionCount is a forward analysis of the most instructions that can
+// have executed, or -1 if there's no bound.
+type instructionCount struct {
+	count int
+}
+
+func (self *instructionCount) Process(ptr InstructionPointer) Analysis {
+	if self.count >= 0 {
+		self.count++
+	}
+	return self
+}
+
+func (self *instructionCount) Combine(other Analysis) Analysis {
+	count := other.(*instructionCount).count
+	if self.count < 0 || count < 0 {
+		self.count = -1
+	} else if count > self.count {
+		self.count = count
+	}
+	return self
+}
+
+func (self *instructionCount) Copy() Analysis {
+	return &instructionCount{self.count}
+}
+
+func (self *instructionCount) Equals(other Analysis) bool {
+	return self.count == other.(*instructionCount).count
+}
+
+func (self *instructionCount) Widen(next Analysis) Analysis {
+	previous := self.count
+	if self.Combine(next); self.count != previous {
+		self.count = -1
+	}
+	return self
+}
+
+// findOp returns the first instruction with the given opcode.
+func findOp(t *testing.T, prog *Program, op OpCode) InstructionPointer {
+	for _, block := range prog.Blocks {
+		for i := range block.Instructions {
+			if block.Instructions[i].Op == op {
+				return InstructionPointer{block, i}
+			}
+		}
+	}
+	t.Fatalf("no %v in program", op)
+	return InstructionPointer{}
+}
+
+func TestForwardDataflow(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		code       string
+		widenAfter int
+		at         OpCode
+		count      int
+	}{
+		// The longer side of a branch reaches the STOP after 5 instructions,
+		// not counting the JUMPDEST, and a limit of zero never widens
+		{"branch", "346007576001505b00", 0, STOP, 5},
+		// An infinite loop only terminates once it's widened
+		{"loop", "5b600056", 2, JUMP, -1},
+	} {
+		prog := analyzeHex(t, test.code, DefaultOptions())
+		result := PerformDataflowAnalysis(prog, &instructionCount{}, DataflowOptions{Direction: Forward, WidenAfter: test.widenAfter})
+		state := result.Before(findOp(t, prog, test.at))
+		if state == nil {
+			t.Errorf("%v: %v wasn't reached", test.name, test.at)
+		} else if count := state.(*instructionCount).count; count != test.count {
+			t.Errorf("%v: got %d instructions before %v, want %d", test.name, count, test.at, test.count)
+		}
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		code string
+	}{
+		{"straight", "60016002015000"},
+		// With no exits, the loop must still be analysed
+		{"infinite loop", "5b600160020150600056"},
+	} {
+		prog := analyzeHex(t, test.code, DefaultOptions())
+		result := PerformLivenessAnalysis(prog)
+		add := findOp(t, prog, ADD)
+
+		var reaching ReachingDefinition
+		add.Get().Annotations.Get(&reaching)
+		before := result.Before(add)
+		if before == nil {
+			t.Fatalf("%v: ADD wasn't analysed", test.name)
+		}
+		live := before.(*Liveness).Live
+		if len(live) != 2 || !live[*reaching[0].First()] || !live[*reaching[1].First()] {
+			t.Errorf("%v: live before ADD are %v, want its operands", test.name, live)
+		}
+		// The sum is only popped
+		if after := result.After(add).(*Liveness).Live; after[add] {
+			t.Errorf("%v: ADD's result is live after it", test.name)
+		}
+
+		var entry LiveDefinitions
+		prog.Entry.Annotations.Get(&entry)
+		if entry == nil || len(entry) != 0 {
+			t.Errorf("%v: live definitions on entry are %v, want none", test.name, entry)
+		}
+	}
+}
+
+func TestLivenessOption(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		options := DefaultOptions()
+		options.Liveness = enabled
+		prog := analyzeHex(t, "60016002015000", options)
+		var live LiveDefinitions
+		prog.Entry.Annotations.Get(&live)
+		if (live != nil) != enabled {
+			t.Errorf("with Liveness %v: got live definitions %v", enabled, live)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package evmdis
+
+import (
+	"fmt"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (self Severity) String() string {
+	if self == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Names of the analyses that record diagnostics
+const (
+	AnalysisReaching    = "reaching"
+	AnalysisExpressions = "expressions"
+	AnalysisValues      = "values"
+)
+
+// Diagnostic describes a problem an analysis encountered with part of a
+// program. Analyses record diagnostics and carry on with the rest of the
+// program rather than failing outright.
+type Diagnostic struct {
+	Severity Severity
+	Analysis string
+	// Block and offset of the instruction the problem was found at, or nil
+	// and -1 if it applies to the whole program
+	Block   *BasicBlock
+	Offset  int
+	Message string
+}
+
+func (self *Diagnostic) String() string {
+	if self.Block == nil {
+		return fmt.Sprintf("%v: %v: %v", self.Severity, self.Analysis, self.Message)
+	}
+	return fmt.Sprintf("%v: %v@0x%X: %v", self.Severity, self.Analysis, self.Offset, self.Message)
+}
+
+// Diagnostics is the list of diagnostics recorded against a program, indexed so
+// that duplicates can be ignored and each block's diagnostics found quickly.
+// The zero value is an empty list.
+type Diagnostics struct {
+	List    []*Diagnostic
+	seen    map[Diagnostic]bool
+	byBlock map[*BasicBlock][]*Diagnostic
+}
+
+// Add records a diagnostic, ignoring it if an identical one has already been
+// recorded; analyses may visit the same code many times.
+func (self *Diagnostics) Add(diagnostic *Diagnostic) {
+	if self.seen == nil {
+		self.seen = make(map[Diagnostic]bool)
+		self.byBlock = make(map[*BasicBlock][]*Diagnostic)
+	}
+	if self.seen[*diagnostic] {
+		return
+	}
+	self.seen[*diagnostic] = true
+	self.byBlock[diagnostic.Block] = append(self.byBlock[diagnostic.Block], diagnostic)
+	self.List = append(self.List, diagnostic)
+}
+
+func (self *Diagnostics) Warnf(analysis string, block *BasicBlock, offset int, format string, args ...interface{}) {
+	self.Add(&Diagnostic{SeverityWarning, analysis, block, offset, fmt.Sprintf(format, args...)})
+}
+
+func (self *Diagnostics) Errorf(analysis string, block *BasicBlock, offset int, format string, args ...interface{}) {
+	self.Add(&Diagnostic{SeverityError, analysis, block, offset, fmt.Sprintf(format, args...)})
+}
+
+// Filter removes the diagnostics for which keep returns false.
+func (self *Diagnostics) Filter(keep func(*Diagnostic) bool) {
+	list := self.List
+	*self = Diagnostics{}
+	for _, diagnostic := range list {
+		if keep(diagnostic) {
+			self.Add(diagnostic)
+		}
+	}
+}
+
+// ForBlock returns the diagnostics recorded against a block, or against the
+// whole program if block is nil.
+func (self *Diagnostics) ForBlock(block *BasicBlock) []*Diagnostic {
+	return self.byBlock[block]
+}
+
+func (self *Diagnostics) HasErrors() bool {
+	for _, diagnostic := range self.List {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package evmdis
+
+import (
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	block := &BasicBlock{Offset: 0x4}
+	var diagnostics Diagnostics
+	diagnostics.Warnf(AnalysisReaching, nil, -1, "whole program")
+	diagnostics.Errorf(AnalysisReaching, block, 0x5, "at 0x%X", 0x5)
+	// Analyses visit code many times, and may report the same problem again
+	diagnostics.Errorf(AnalysisReaching, block, 0x5, "at 0x%X", 0x5)
+	diagnostics.Warnf(AnalysisValues, block, 0x6, "at 0x%X", 0x6)
+
+	if len(diagnostics.List) != 3 {
+		t.Fatalf("got diagnostics %v, want 3", diagnostics.List)
+	}
+	if got := diagnostics.List[1].String(); got != "error: reaching@0x5: at 0x5" {
+		t.Errorf("got %q", got)
+	}
+	if got := diagnostics.List[0].String(); got != "warning: reaching: whole program" {
+		t.Errorf("got %q", got)
+	}
+	if len(diagnostics.ForBlock(block)) != 2 || len(diagnostics.ForBlock(nil)) != 1 {
+		t.Errorf("got %v for the block and %v for the program", diagnostics.ForBlock(block), diagnostics.ForBlock(nil))
+	}
+	if !diagnostics.HasErrors() {
+		t.Errorf("the error wasn't found")
+	}
+
+	diagnostics.Filter(func(diagnostic *Diagnostic) bool { return diagnostic.Severity == SeverityWarning })
+	if len(diagnostics.List) != 2 || len(diagnostics.ForBlock(block)) != 1 || diagnostics.HasErrors() {
+		t.Errorf("got diagnostics %v after removing errors", diagnostics.List)
+	}
+	// A removed diagnostic can be recorded again
+	diagnostics.Errorf(AnalysisReaching, block, 0x5, "at 0x%X", 0x5)
+	if len(diagnostics.List) != 3 {
+		t.Errorf("got diagnostics %v, want the error back", diagnostics.List)
+	}
+
+	var empty Diagnostics
+	if empty.HasErrors() || empty.ForBlock(nil) != nil {
+		t.Errorf("the zero value isn't empty")
+	}
+}
+
+func TestDiagnosticsContinue(t *testing.T) {
+	// The ADD at 0x4 underflows when the JUMPI at 0x3 falls through, but the
+	// code it jumps to is still analysed
+	prog := analyzeHex(t, "34600557015b600000", DefaultOptions())
+	add := findOp(t, prog, ADD)
+	found := false
+	for _, diagnostic := range prog.Diagnostics.ForBlock(add.OriginBlock) {
+		found = found || (diagnostic.Analysis == AnalysisReaching && diagnostic.Severity == SeverityError && diagnostic.Offset == 0x4)
+	}
+	if !found {
+		t.Errorf("got diagnostics %v, want an underflow at 0x4", prog.Diagnostics.List)
+	}
+	stop := prog.JumpDestinations[0x5]
+	var reaching ReachingDefinition
+	stop.Annotations.Get(&reaching)
+	if reaching == nil {
+		t.Errorf("the code after the underflow wasn't analysed")
+	}
+}
@@ -0,0 +1,346 @@
+package evmdis
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+const (
+	// Selectors are compared against in sequence
+	DispatcherLinear = "linear"
+	// Selectors are split into ranges with GT or LT before being compared
+	DispatcherBinarySearch = "binary search"
+	// The selector is reduced to a bucket with MOD or a mask, which is used
+	// to compute a jump into a table of comparisons
+	DispatcherHashTable = "hash table"
+)
+
+// ExternalFunction is a function of a contract that the dispatcher jumps to when
+// called with its selector.
+type ExternalFunction struct {
+	Selector uint32
+	Entry    *BasicBlock
+	// Instruction that compares the selector against this function's
+	Comparison InstructionPointer
+	// Blocks that are only reachable from this function, ordered by offset
+	Blocks []*BasicBlock
+	// Signatures the selector may have been derived from, if known
+	Signatures []string
+}
+
+func (self *ExternalFunction) String() string {
+	return fmt.Sprintf("function 0x%08x", self.Selector)
+}
+
+// Dispatcher is the code at the start of a contract that compares the function
+// selector in the calldata against each function the contract implements, and
+// jumps to the matching one.
+type Dispatcher struct {
+	Style string
+	// Blocks that make up the dispatcher, ordered by offset
+	Blocks []*BasicBlock
+	// Functions, ordered by selector
+	Functions []*ExternalFunction
+	// Block the dispatcher most often jumps to when no selector matches, if
+	// any; usually a fallback function or a revert
+	Fallback *BasicBlock
+}
+
+var (
+	selectorMask  = big.NewInt(0xFFFFFFFF)
+	selectorShift = big.NewInt(0xE0)
+	// 2 ** 0xE0, which the selector used to be extracted by dividing by
+	selectorDivisor = new(big.Int).Lsh(big.NewInt(1), 0xE0)
+)
+
+type dispatcherAnalysis struct {
+	// True if the calldata is stored to memory at 0x1C, so that MLOAD(0x0)
+	// reads the selector
+	selectorInMemory bool
+}
+
+// operands returns the definitions reaching each argument of the instruction at
+// ptr.
+func operands(ptr InstructionPointer) ReachingDefinition {
+	var reaching ReachingDefinition
+	ptr.Get().Annotations.Get(&reaching)
+	return reaching
+}
+
+// operand returns the single definition reaching argument i of the instruction
+// at ptr, if there is exactly one.
+func operand(ptr InstructionPointer, i int) (InstructionPointer, bool) {
+	reaching := operands(ptr)
+	if i >= len(reaching) || len(reaching[i]) != 1 {
+		return InstructionPointer{}, false
+	}
+	return *reaching[i].First(), true
+}
+
+// constantOperand returns the value of argument i of the instruction at ptr, if
+// it's a constant.
+func constantOperand(ptr InstructionPointer, i int) *big.Int {
+	reaching := operands(ptr)
+	if i >= len(reaching) {
+		return nil
+	}
+	var value *big.Int
+	for pointer := range reaching[i] {
+		values := foldConstant(pointer, 0)
+		if len(values) != 1 || (value != nil && value.Cmp(values[0]) != 0) {
+			return nil
+		}
+		value = values[0]
+	}
+	return value
+}
+
+// isCalldataWord returns true if ptr defines CALLDATALOAD(0x0).
+func (self *dispatcherAnalysis) isCalldataWord(ptr InstructionPointer) bool {
+	if ptr.Get().Op != CALLDATALOAD {
+		return false
+	}
+	offset := constantOperand(ptr, 0)
+	return offset != nil && offset.Sign() == 0
+}
+
+// isSelector returns true if every definition in pointers is the function
+// selector, extracted from the calldata in one of the ways compilers do so.
+func (self *dispatcherAnalysis) isSelector(pointers InstructionPointerSet) bool {
+	if len(pointers) == 0 {
+		return false
+	}
+	for pointer := range pointers {
+		if !self.isSelectorDefinition(pointer) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *dispatcherAnalysis) isSelectorDefinition(ptr InstructionPointer) bool {
+	reaching := operands(ptr)
+	if reaching == nil {
+		return false
+	}
+
+	switch ptr.Get().Op {
+	case SHR:
+		// CALLDATALOAD(0x0) >> 0xE0
+		value, ok := operand(ptr, 1)
+		shift := constantOperand(ptr, 0)
+		return ok && shift != nil && shift.Cmp(selectorShift) == 0 && self.isCalldataWord(value)
+	case DIV:
+		// CALLDATALOAD(0x0) / 0x2 ** 0xE0
+		value, ok := operand(ptr, 0)
+		divisor := constantOperand(ptr, 1)
+		return ok && divisor != nil && divisor.Cmp(selectorDivisor) == 0 && self.isCalldataWord(value)
+	case AND:
+		// 0xFFFFFFFF & selector
+		for i := 0; i < 2; i++ {
+			if mask := constantOperand(ptr, i); mask != nil && mask.Cmp(selectorMask) == 0 {
+				return self.isSelector(reaching[1-i])
+			}
+		}
+	case MLOAD:
+		// MLOAD(0x0), after MSTORE(0x1C, CALLDATALOAD(0x0))
+		offset := constantOperand(ptr, 0)
+		return self.selectorInMemory && offset != nil && offset.Sign() == 0
+	}
+	return false
+}
+
+// comparison returns the constant a selector is compared against by the
+// instruction at ptr, if it's such a comparison.
+func (self *dispatcherAnalysis) comparison(ptr InstructionPointer) *big.Int {
+	reaching := operands(ptr)
+	if len(reaching) != 2 {
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if value := constantOperand(ptr, i); value != nil && value.Cmp(selectorMask) <= 0 && self.isSelector(reaching[1-i]) {
+			return value
+		}
+	}
+	return nil
+}
+
+// branchOn returns the block of the JUMPI whose condition is the value defined
+// at ptr, possibly negated by ISZERO, and whether the jump is taken when the
+// value is non-zero.
+func branchOn(ptr InstructionPointer, taken bool) (*BasicBlock, bool) {
+	var reaches ReachesDefinition
+	ptr.Get().Annotations.Get(&reaches)
+	if len(reaches) != 1 {
+		return nil, false
+	}
+	consumer := reaches[0]
+	switch consumer.Get().Op {
+	case ISZERO:
+		return branchOn(consumer, !taken)
+	case JUMPI, RJUMPI:
+		var reaching ReachingDefinition
+		consumer.Get().Annotations.Get(&reaching)
+		if len(reaching) == 2 && reaching[1][ptr] && consumer.OriginIndex == len(consumer.OriginBlock.Instructions)-1 {
+			return consumer.OriginBlock, taken
+		}
+	}
+	return nil, false
+}
+
+// PerformDispatcherAnalysis recognises the function dispatcher of a contract,
+// setting Program.Dispatcher and annotating the entry block of each function
+// with its ExternalFunction. Reaches and dominator analysis must already have
+// been performed.
+func PerformDispatcherAnalysis(prog *Program) {
+	self := &dispatcherAnalysis{}
+
+	var reachable []InstructionPointer
+	for _, block := range prog.Blocks {
+		for i := range block.Instructions {
+			var reaching ReachingDefinition
+			block.Instructions[i].Annotations.Get(&reaching)
+			if reaching != nil {
+				reachable = append(reachable, InstructionPointer{block, i})
+			}
+		}
+	}
+
+	for _, ptr := range reachable {
+		if ptr.Get().Op != MSTORE {
+			continue
+		}
+		offset := constantOperand(ptr, 0)
+		if value, ok := operand(ptr, 1); ok && offset != nil && offset.Int64() == 0x1C && self.isCalldataWord(value) {
+			self.selectorInMemory = true
+		}
+	}
+
+	dispatcher := &Dispatcher{Style: DispatcherLinear}
+	comparisonBlocks := make(map[*BasicBlock]bool)
+	entries := make(map[*BasicBlock]*ExternalFunction)
+	selectors := make(map[uint32]bool)
+	for _, ptr := range reachable {
+		op := ptr.Get().Op
+		switch op {
+		case EQ, XOR, SUB:
+			value := self.comparison(ptr)
+			if value == nil {
+				continue
+			}
+			// EQ is non-zero if the selector matches; XOR and SUB are zero
+			block, matchTaken := branchOn(ptr, op == EQ)
+			if block == nil {
+				continue
+			}
+			matchKind := EdgeConditionalFalse
+			if matchTaken {
+				matchKind = EdgeConditionalTrue
+			}
+			var entry *BasicBlock
+			for _, edge := range block.Successors {
+				if edge.Kind == matchKind {
+					entry = edge.To
+				}
+			}
+			selector := uint32(value.Uint64())
+			if entry == nil || selectors[selector] {
+				continue
+			}
+			selectors[selector] = true
+			function := &ExternalFunction{Selector: selector, Entry: entry, Comparison: ptr}
+			dispatcher.Functions = append(dispatcher.Functions, function)
+			entries[entry] = function
+			comparisonBlocks[block] = true
+		case GT, LT:
+			if self.comparison(ptr) != nil {
+				dispatcher.Style = DispatcherBinarySearch
+				comparisonBlocks[ptr.OriginBlock] = true
+			}
+		case MOD:
+			if self.isSelector(operands(ptr)[0]) {
+				dispatcher.Style = DispatcherHashTable
+				comparisonBlocks[ptr.OriginBlock] = true
+			}
+		case AND:
+			for i := 0; i < 2; i++ {
+				if mask := constantOperand(ptr, i); mask != nil && mask.Cmp(selectorMask) < 0 && self.isSelector(operands(ptr)[1-i]) {
+					dispatcher.Style = DispatcherHashTable
+					comparisonBlocks[ptr.OriginBlock] = true
+				}
+			}
+		}
+	}
+	if len(dispatcher.Functions) == 0 {
+		prog.Dispatcher = nil
+		return
+	}
+
+	// The dispatcher is made up of the blocks containing comparisons, and
+	// those that lead up to them
+	inDispatcher := make(map[*BasicBlock]bool)
+	for _, block := range prog.Blocks {
+		if entries[block] != nil {
+			continue
+		}
+		if comparisonBlocks[block] {
+			inDispatcher[block] = true
+			continue
+		}
+		for comparisonBlock := range comparisonBlocks {
+			if Dominates(block, comparisonBlock) {
+				inDispatcher[block] = true
+				break
+			}
+		}
+	}
+
+	// Blocks belong to a function if they are reachable from its entry,
+	// without passing through the dispatcher, and not from any other function
+	owners := make(map[*BasicBlock][]*ExternalFunction)
+	for _, function := range dispatcher.Functions {
+		seen := map[*BasicBlock]bool{function.Entry: true}
+		pending := []*BasicBlock{function.Entry}
+		for len(pending) > 0 {
+			var block *BasicBlock
+			block, pending = pending[len(pending)-1], pending[:len(pending)-1]
+			owners[block] = append(owners[block], function)
+			for _, edge := range block.Successors {
+				if !seen[edge.To] && !inDispatcher[edge.To] && entries[edge.To] == nil {
+					seen[edge.To] = true
+					pending = append(pending, edge.To)
+				}
+			}
+		}
+	}
+
+	// The fallback is the block outside the dispatcher that it jumps to most
+	fallbackEdges := make(map[*BasicBlock]int)
+	for _, block := range prog.Blocks {
+		if inDispatcher[block] {
+			dispatcher.Blocks = append(dispatcher.Blocks, block)
+			for _, edge := range block.Successors {
+				if !inDispatcher[edge.To] && entries[edge.To] == nil {
+					fallbackEdges[edge.To]++
+				}
+			}
+		}
+		if len(owners[block]) == 1 {
+			owners[block][0].Blocks = append(owners[block][0].Blocks, block)
+		}
+	}
+	for _, block := range prog.Blocks {
+		if fallbackEdges[block] > 0 && (dispatcher.Fallback == nil || fallbackEdges[block] > fallbackEdges[dispatcher.Fallback]) {
+			dispatcher.Fallback = block
+		}
+	}
+
+	sort.Slice(dispatcher.Functions, func(i, j int) bool {
+		return dispatcher.Functions[i].Selector < dispatcher.Functions[j].Selector
+	})
+	for _, function := range dispatcher.Functions {
+		function.Entry.Annotations.Set(&function)
+	}
+	prog.Dispatcher = dispatcher
+}
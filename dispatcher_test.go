@@ -0,0 +1,76 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// analyzeFile analyses one of the programs in tests, returning the program
+// with the given name.
+func analyzeFile(t *testing.T, path, name string, options Options) *Program {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	options.Format = ""
+	result, err := Analyze(bytecode, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, program := range result.Programs {
+		if program.Name == name {
+			return program.Program
+		}
+	}
+	t.Fatalf("%v has no %v program", path, name)
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	options := DefaultOptions()
+	options.Constructor = true
+	for _, test := range []struct {
+		path      string
+		options   Options
+		selectors []uint32
+		fallback  int
+	}{
+		{"tests/attack1.bin", DefaultOptions(), nil, 0},
+		{"tests/loop.bin", DefaultOptions(), []uint32{0xf8a8fd6d}, 0x19},
+		{"tests/ballot.bin", options, []uint32{0x0121b93f, 0x013cf08b, 0x2e4176cf, 0x5c19a95c, 0x609ff1bd, 0x9e7b8d61, 0xa3ec138d, 0xe2ba53f0}, 0x8d},
+	} {
+		dispatcher := analyzeFile(t, test.path, "code", test.options).Dispatcher
+		if dispatcher == nil {
+			if test.selectors != nil {
+				t.Errorf("%v: no dispatcher found", test.path)
+			}
+			continue
+		}
+		if test.selectors == nil {
+			t.Errorf("%v: unexpected dispatcher %+v", test.path, dispatcher)
+			continue
+		}
+
+		if dispatcher.Style != DispatcherLinear || dispatcher.Fallback == nil || dispatcher.Fallback.Offset != test.fallback {
+			t.Errorf("%v: got a %v dispatcher falling back to %v", test.path, dispatcher.Style, dispatcher.Fallback)
+		}
+		if len(dispatcher.Functions) != len(test.selectors) {
+			t.Errorf("%v: got %d functions, want %d", test.path, len(dispatcher.Functions), len(test.selectors))
+			continue
+		}
+		for i, function := range dispatcher.Functions {
+			if function.Selector != test.selectors[i] || function.Entry == nil || function.Blocks[0] != function.Entry {
+				t.Errorf("%v: got %v entered at %v, want function 0x%08x", test.path, function, function.Entry, test.selectors[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package evmdis
+
+// Dominators annotates each block reachable from the entry point with its
+// place in the dominator tree. A block dominates another if every path from the
+// entry to the other block passes through it.
+type Dominators struct {
+	// Closest block that dominates this one, or nil for the entry block
+	Immediate *BasicBlock
+	// Blocks this one is the immediate dominator of
+	Children []*BasicBlock
+}
+
+// PostDominators annotates each block from which an exit can be reached with
+// its place in the post-dominator tree. A block post-dominates another if every
+// path from the other block to an exit passes through it.
+type PostDominators struct {
+	// Closest block that post-dominates this one, or nil for exit blocks and
+	// blocks whose paths to an exit have no block in common
+	Immediate *BasicBlock
+	Children  []*BasicBlock
+}
+
+func successorBlocks(block *BasicBlock) []*BasicBlock {
+	var ret []*BasicBlock
+	for _, edge := range block.Successors {
+		ret = append(ret, edge.To)
+	}
+	return ret
+}
+
+func predecessorBlocks(block *BasicBlock) []*BasicBlock {
+	var ret []*BasicBlock
+	for _, edge := range block.Predecessors {
+		ret = append(ret, edge.From)
+	}
+	return ret
+}
+
+// immediateDominators computes the immediate dominator of each block reachable
+// from roots by following succs, using the iterative algorithm of Cooper, Harvey
+// and Kennedy. preds must be the inverse of succs. The immediate dominator of
+// the roots, and of blocks dominated by more than one root, is nil.
+func immediateDominators(roots []*BasicBlock, succs, preds func(*BasicBlock) []*BasicBlock) map[*BasicBlock]*BasicBlock {
+	// A virtual root, preceding all the real ones
+	root := &BasicBlock{}
+	successors := func(block *BasicBlock) []*BasicBlock {
+		if block == root {
+			return roots
+		}
+		return succs(block)
+	}
+	isRoot := make(map[*BasicBlock]bool)
+	for _, block := range roots {
+		isRoot[block] = true
+	}
+	predecessors := func(block *BasicBlock) []*BasicBlock {
+		if isRoot[block] {
+			return append(preds(block), root)
+		}
+		return preds(block)
+	}
+
+	// Number the blocks in postorder
+	order := make(map[*BasicBlock]int)
+	var postorder []*BasicBlock
+	var visit func(*BasicBlock)
+	visit = func(block *BasicBlock) {
+		order[block] = -1
+		for _, next := range successors(block) {
+			if _, ok := order[next]; !ok {
+				visit(next)
+			}
+		}
+		order[block] = len(postorder)
+		postorder = append(postorder, block)
+	}
+	visit(root)
+
+	idom := map[*BasicBlock]*BasicBlock{root: root}
+	intersect := func(a, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		// Process blocks in reverse postorder, skipping the root
+		for i := len(postorder) - 2; i >= 0; i-- {
+			block := postorder[i]
+			var newIdom *BasicBlock
+			for _, pred := range predecessors(block) {
+				if idom[pred] == nil {
+					continue
+				}
+				if newIdom == nil {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[block] != newIdom {
+				idom[block] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	delete(idom, root)
+	for block, dominator := range idom {
+		if dominator == root {
+			idom[block] = nil
+		}
+	}
+	return idom
+}
+
+// PerformDominatorAnalysis annotates blocks with their Dominators and
+// PostDominators, using the control flow graph found by the reaching analysis.
+func PerformDominatorAnalysis(prog *Program) {
+	if prog.Entry == nil {
+		return
+	}
+
+	dominators := make(map[*BasicBlock]*Dominators)
+	idom := immediateDominators([]*BasicBlock{prog.Entry}, successorBlocks, predecessorBlocks)
+	for _, block := range prog.Blocks {
+		if _, ok := idom[block]; ok {
+			dominators[block] = &Dominators{Immediate: idom[block]}
+		}
+	}
+	for _, block := range prog.Blocks {
+		if dominator := dominators[block]; dominator != nil {
+			if dominator.Immediate != nil {
+				parent := dominators[dominator.Immediate]
+				parent.Children = append(parent.Children, block)
+			}
+			block.Annotations.Set(&dominator)
+		}
+	}
+
+	postDominators := make(map[*BasicBlock]*PostDominators)
+	ipdom := immediateDominators(prog.Exits, predecessorBlocks, successorBlocks)
+	for _, block := range prog.Blocks {
+		if _, ok := ipdom[block]; ok {
+			postDominators[block] = &PostDominators{Immediate: ipdom[block]}
+		}
+	}
+	for _, block := range prog.Blocks {
+		if postDominator := postDominators[block]; postDominator != nil {
+			if postDominator.Immediate != nil {
+				parent := postDominators[postDominator.Immediate]
+				parent.Children = append(parent.Children, block)
+			}
+			block.Annotations.Set(&postDominator)
+		}
+	}
+}
+
+// Dominates returns true if a dominates b. Every block dominates itself.
+func Dominates(a, b *BasicBlock) bool {
+	for b != nil {
+		if a == b {
+			return true
+		}
+		var dominators *Dominators
+		b.Annotations.Get(&dominators)
+		if dominators == nil {
+			return false
+		}
+		b = dominators.Immediate
+	}
+	return false
+}
+
+// PostDominates returns true if a post-dominates b. Every block post-dominates
+// itself.
+func PostDominates(a, b *BasicBlock) bool {
+	for b != nil {
+		if a == b {
+			return true
+		}
+		var postDominators *PostDominators
+		b.Annotations.Get(&postDominators)
+		if postDominators == nil {
+			return false
+		}
+		b = postDominators.Immediate
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package evmdis
+
+import (
+	"fmt"
+	"strings"
+)
+
+var edgeKindToDotAttributes = map[EdgeKind]string{
+	EdgeFallthrough:      `color="black"`,
+	EdgeJump:             `color="blue"`,
+	EdgeConditionalTrue:  `color="darkgreen", label="true"`,
+	EdgeConditionalFalse: `color="red", label="false"`,
+	EdgeUnresolved:       `color="orange", style="dashed"`,
+}
+
+// escapeDot escapes a line of text for use in a left justified DOT label.
+func escapeDot(line string) string {
+	line = strings.Replace(line, `\`, `\\`, -1)
+	line = strings.Replace(line, `"`, `\"`, -1)
+	return line + `\l`
+}
+
+// RenderDot renders the control flow graph of an analyzed program as a Graphviz
+// DOT digraph. Each block is labelled with its jump label, diagnostics, stack
+// prestate and expressions; unreachable blocks are drawn dashed and grey.
+func RenderDot(prog *Program) string {
+	ret := "digraph program {\n"
+	ret += "\tnode [shape=box, fontname=\"monospace\"];\n"
+
+	for _, block := range prog.Blocks {
+		var lines []string
+
+		var label *JumpLabel
+		block.Annotations.Get(&label)
+		if label != nil {
+			lines = append(lines, label.String())
+		}
+		for _, diagnostic := range prog.Diagnostics.ForBlock(block) {
+			lines = append(lines, fmt.Sprintf("# %v", diagnostic))
+		}
+
+		var reaching ReachingDefinition
+		block.Annotations.Get(&reaching)
+		attributes := ""
+		if reaching == nil {
+			attributes = `, style="dashed", color="grey", fontcolor="grey"`
+		} else {
+			lines = append(lines, fmt.Sprintf("# Stack: %v", reaching))
+		}
+
+		offset := block.Offset
+		for i := range block.Instructions {
+			inst := &block.Instructions[i]
+			var expression Expression
+			inst.Annotations.Get(&expression)
+			if expression != nil {
+				if prog.StackWrites(inst) == 1 && !inst.Op.IsDup() {
+					lines = append(lines, fmt.Sprintf("0x%X  PUSH(%v)", offset, expression))
+				} else {
+					lines = append(lines, fmt.Sprintf("0x%X  %v", offset, expression))
+				}
+			} else if reaching == nil {
+				lines = append(lines, fmt.Sprintf("0x%X  %v", offset, inst))
+			}
+			offset += inst.Size()
+		}
+		if len(lines) == 0 {
+			lines = append(lines, fmt.Sprintf("0x%X", block.Offset))
+		}
+
+		escaped := make([]string, len(lines))
+		for i, line := range lines {
+			escaped[i] = escapeDot(line)
+		}
+		ret += fmt.Sprintf("\tblock_%d [label=\"%s\"%s];\n", block.Offset, strings.Join(escaped, ""), attributes)
+	}
+
+	for _, block := range prog.Blocks {
+		for _, edge := range block.Successors {
+			ret += fmt.Sprintf("\tblock_%d -> block_%d [%s];\n", edge.From.Offset, edge.To.Offset, edgeKindToDotAttributes[edge.Kind])
+		}
+	}
+
+	ret += "}\n"
+	return ret
+}
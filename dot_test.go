@@ -0,0 +1,51 @@
+package evmdis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeDot(t *testing.T) {
+	for _, test := range []struct {
+		line, escaped string
+	}{
+		{"", `\l`},
+		{"0x4  JUMPI(:label0, 0x0)", `0x4  JUMPI(:label0, 0x0)\l`},
+		{`"a\b"`, `\"a\\b\"\l`},
+	} {
+		if got := escapeDot(test.line); got != test.escaped {
+			t.Errorf("escapeDot(%q): got %q, want %q", test.line, got, test.escaped)
+		}
+	}
+}
+
+func TestRenderDot(t *testing.T) {
+	// The JUMPI at 0x4 never jumps, so the STOP at 0x9 can't be reached
+	prog := analyzeHex(t, "6000600857600a565b005b00", DefaultOptions())
+	want := `digraph program {
+	node [shape=box, fontname="monospace"];
+	block_0 [label="# Stack: []\l0x4  JUMPI(:label0, 0x0)\l"];
+	block_5 [label="# Stack: []\l0x7  JUMP(:label1)\l"];
+	block_9 [label=":label0\l0x9  STOP\l", style="dashed", color="grey", fontcolor="grey"];
+	block_11 [label=":label1\l# Stack: []\l0xB  STOP()\l"];
+	block_0 -> block_5 [color="red", label="false"];
+	block_5 -> block_11 [color="blue"];
+}
+`
+	if got := RenderDot(prog); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	// Each kind of edge is drawn differently
+	dot := RenderDot(analyzeHex(t, "346009576001505b005b60003556", DefaultOptions()))
+	for _, edge := range []string{
+		`block_0 -> block_10 [color="darkgreen", label="true"];`,
+		`block_0 -> block_4 [color="red", label="false"];`,
+		`block_4 -> block_8 [color="black"];`,
+		`block_10 -> block_8 [color="orange", style="dashed"];`,
+	} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("got\n%v\nwant it to contain %v", dot, edge)
+		}
+	}
+}
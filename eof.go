@@ -0,0 +1,516 @@
+package evmdis
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// EVM Object Format (EIP-3540, EIP-4750, EIP-5450) container structure
+const (
+	eofKindTypes     = 0x01
+	eofKindCode      = 0x02
+	eofKindContainer = 0x03
+	eofKindData      = 0x04
+	eofTerminator    = 0x00
+
+	// Outputs value of a code section that never returns
+	eofNonReturning = 0x80
+)
+
+// Opcodes that are only defined inside EOF containers
+var eofOnlyOpCodes = map[OpCode]bool{
+	DATALOAD:        true,
+	DATALOADN:       true,
+	DATASIZE:        true,
+	DATACOPY:        true,
+	RJUMP:           true,
+	RJUMPI:          true,
+	RJUMPV:          true,
+	CALLF:           true,
+	RETF:            true,
+	JUMPF:           true,
+	DUPN:            true,
+	SWAPN:           true,
+	EXCHANGE:        true,
+	EOFCREATE:       true,
+	RETURNCONTRACT:  true,
+	RETURNDATALOAD:  true,
+	EXTCALL:         true,
+	EXTDELEGATECALL: true,
+	EXTSTATICCALL:   true,
+}
+
+// Opcodes that are rejected inside EOF containers
+var legacyOnlyOpCodes = map[OpCode]bool{
+	CALLCODE:     true,
+	SELFDESTRUCT: true,
+	JUMP:         true,
+	JUMPI:        true,
+	PC:           true,
+	CREATE:       true,
+	CREATE2:      true,
+	CALL:         true,
+	STATICCALL:   true,
+	DELEGATECALL: true,
+	CODESIZE:     true,
+	CODECOPY:     true,
+	EXTCODESIZE:  true,
+	EXTCODECOPY:  true,
+	EXTCODEHASH:  true,
+	GAS:          true,
+}
+
+// IsAvailableInEOF returns true if op is a defined opcode inside an EOF
+// container in the given fork.
+func (op OpCode) IsAvailableInEOF(fork Fork) bool {
+	if _, ok := opCodeToString[op]; !ok || legacyOnlyOpCodes[op] {
+		return false
+	}
+	return opCodeIntroducedIn[op] <= fork
+}
+
+// FunctionType describes the stack effect of an EOF code section.
+type FunctionType struct {
+	Inputs           int
+	Outputs          int
+	NonReturning     bool
+	MaxStackIncrease int
+}
+
+// Container is a parsed EOF container. Each code section is decoded into its
+// own Program, with offsets relative to the start of the section.
+type Container struct {
+	Version       byte
+	Types         []FunctionType
+	Sections      []*Program
+	Subcontainers [][]byte
+	Data          []byte
+}
+
+// IsEOF returns true if bytecode starts with the EOF magic, 0xEF00.
+func IsEOF(bytecode []byte) bool {
+	return len(bytecode) >= 2 && bytecode[0] == 0xef && bytecode[1] == 0x00
+}
+
+type eofReader struct {
+	data []byte
+	pos  int
+}
+
+func (self *eofReader) read(n int) ([]byte, error) {
+	if n > len(self.data)-self.pos {
+		return nil, fmt.Errorf("Unexpected end of EOF container at 0x%X", self.pos)
+	}
+	b := self.data[self.pos : self.pos+n]
+	self.pos += n
+	return b, nil
+}
+
+func (self *eofReader) readUint(size int) (int, error) {
+	b, err := self.read(size)
+	if err != nil {
+		return 0, err
+	}
+	value := 0
+	for _, v := range b {
+		value = value<<8 | int(v)
+	}
+	return value, nil
+}
+
+func (self *eofReader) expectKind(kind byte) error {
+	b, err := self.read(1)
+	if err != nil {
+		return err
+	}
+	if b[0] != kind {
+		return fmt.Errorf("Expected EOF section kind 0x%02X at 0x%X, found 0x%02X", kind, self.pos-1, b[0])
+	}
+	return nil
+}
+
+// readSizes reads a section count followed by a size of sizeBytes bytes for each.
+func (self *eofReader) readSizes(sizeBytes int) ([]int, error) {
+	count, err := self.readUint(2)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int, count)
+	for i := range sizes {
+		if sizes[i], err = self.readUint(sizeBytes); err != nil {
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
+
+// ParseContainer parses an EOF container and decodes each of its code sections.
+func ParseContainer(bytecode []byte, fork Fork) (*Container, error) {
+	if !IsEOF(bytecode) {
+		return nil, fmt.Errorf("Bytecode does not start with the EOF magic")
+	}
+	reader := &eofReader{data: bytecode, pos: 2}
+	version, err := reader.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("Unsupported EOF version %d", version)
+	}
+
+	// Header
+	if err := reader.expectKind(eofKindTypes); err != nil {
+		return nil, err
+	}
+	typesSize, err := reader.readUint(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.expectKind(eofKindCode); err != nil {
+		return nil, err
+	}
+	codeSizes, err := reader.readSizes(2)
+	if err != nil {
+		return nil, err
+	}
+	if typesSize != len(codeSizes)*4 {
+		return nil, fmt.Errorf("EOF type section size %d doesn't match %d code sections", typesSize, len(codeSizes))
+	}
+	var containerSizes []int
+	if reader.pos < len(bytecode) && bytecode[reader.pos] == eofKindContainer {
+		reader.pos++
+		if containerSizes, err = reader.readSizes(4); err != nil {
+			return nil, err
+		}
+	}
+	if err := reader.expectKind(eofKindData); err != nil {
+		return nil, err
+	}
+	dataSize, err := reader.readUint(2)
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.expectKind(eofTerminator); err != nil {
+		return nil, err
+	}
+
+	// Body
+	container := &Container{
+		Version: byte(version),
+		Types:   make([]FunctionType, len(codeSizes)),
+	}
+	for i := range container.Types {
+		b, err := reader.read(4)
+		if err != nil {
+			return nil, err
+		}
+		container.Types[i] = FunctionType{
+			Inputs:           int(b[0]),
+			Outputs:          int(b[1]),
+			NonReturning:     b[1] == eofNonReturning,
+			MaxStackIncrease: int(binary.BigEndian.Uint16(b[2:])),
+		}
+		if container.Types[i].NonReturning {
+			container.Types[i].Outputs = 0
+		}
+	}
+	for i, size := range codeSizes {
+		code, err := reader.read(size)
+		if err != nil {
+			return nil, err
+		}
+		section, err := decodeEOFSection(code, fork)
+		if err != nil {
+			return nil, fmt.Errorf("Code section %d: %v", i, err)
+		}
+		section.Container = container
+		section.Section = i
+		section.createInputs()
+		container.Sections = append(container.Sections, section)
+	}
+	for _, size := range containerSizes {
+		subcontainer, err := reader.read(size)
+		if err != nil {
+			return nil, err
+		}
+		container.Subcontainers = append(container.Subcontainers, subcontainer)
+	}
+	// The data section may be truncated in initcode; the missing part is
+	// appended by RETURNCONTRACT at deploy time.
+	if remaining := len(bytecode) - reader.pos; remaining < dataSize {
+		dataSize = remaining
+	}
+	container.Data, _ = reader.read(dataSize)
+
+	return container, nil
+}
+
+// decodeEOFSection decodes a single EOF code section. Unlike legacy code, all
+// jumps are static, so basic blocks are split at the targets of RJUMP, RJUMPI
+// and RJUMPV, and those targets are recorded as the block's StaticTargets.
+func decodeEOFSection(code []byte, fork Fork) (*Program, error) {
+	var instructions []Instruction
+	var offsets []int
+	targets := make(map[int][]int)
+	leaders := map[int]bool{0: true}
+
+	for pc := 0; pc < len(code); {
+		op := OpCode(code[pc])
+		if !op.IsAvailableInEOF(fork) {
+			instructions = append(instructions, Instruction{
+				Op:          INVALID,
+				Arg:         big.NewInt(int64(op)),
+				Undefined:   true,
+				Annotations: NewTypeMap(),
+			})
+			offsets = append(offsets, pc)
+			pc += 1
+			leaders[pc] = true
+			continue
+		}
+
+		size := op.OperandSize()
+		if op == RJUMPV && pc+1 < len(code) {
+			size += (int(code[pc+1]) + 1) * 2
+		}
+		if pc+size >= len(code) {
+			return nil, fmt.Errorf("Truncated immediate for %v at 0x%X", op, pc)
+		}
+
+		inst := Instruction{
+			Op:          op,
+			Annotations: NewTypeMap(),
+		}
+		if size > 0 {
+			inst.Immediate = code[pc+1 : pc+1+size]
+		}
+		next := pc + 1 + size
+
+		switch op {
+		case RJUMP, RJUMPI:
+			target := next + int(int16(binary.BigEndian.Uint16(inst.Immediate)))
+			targets[pc] = []int{target}
+			inst.Arg = big.NewInt(int64(target))
+		case RJUMPV:
+			for i := 1; i < size; i += 2 {
+				target := next + int(int16(binary.BigEndian.Uint16(inst.Immediate[i:])))
+				targets[pc] = append(targets[pc], target)
+			}
+		default:
+			if size > 0 || op.IsPush() {
+				inst.Arg = new(big.Int).SetBytes(inst.Immediate)
+			}
+		}
+
+		for _, target := range targets[pc] {
+			leaders[target] = true
+		}
+		if op == RJUMP || op == RJUMPI || op == RJUMPV || op == RETF || op == JUMPF || op.Halts() {
+			leaders[next] = true
+		}
+
+		instructions = append(instructions, inst)
+		offsets = append(offsets, pc)
+		pc = next
+	}
+
+	// Static jumps must land on the start of an instruction in the section
+	starts := make(map[int]bool, len(offsets))
+	for _, offset := range offsets {
+		starts[offset] = true
+	}
+	for i, offset := range offsets {
+		for _, target := range targets[offset] {
+			if !starts[target] {
+				return nil, fmt.Errorf("Invalid target 0x%X for %v at 0x%X", target, instructions[i].Op, offset)
+			}
+		}
+	}
+
+	program := &Program{
+		JumpDestinations: make(map[int]*BasicBlock),
+		Fork:             fork,
+	}
+	blocks := make(map[int]*BasicBlock)
+	var currentBlock *BasicBlock
+	for i, inst := range instructions {
+		if leaders[offsets[i]] || currentBlock == nil {
+			newBlock := &BasicBlock{
+				Offset:      offsets[i],
+				Annotations: NewTypeMap(),
+			}
+			if currentBlock != nil {
+				currentBlock.Next = newBlock
+			}
+			program.Blocks = append(program.Blocks, newBlock)
+			blocks[offsets[i]] = newBlock
+			currentBlock = newBlock
+		}
+		currentBlock.Instructions = append(currentBlock.Instructions, inst)
+	}
+
+	for _, block := range program.Blocks {
+		last := len(block.Instructions) - 1
+		for _, target := range targets[block.OffsetOf(&block.Instructions[last])] {
+			block.StaticTargets = append(block.StaticTargets, blocks[target])
+		}
+	}
+
+	return program, nil
+}
+
+// createInputs creates the synthetic block that defines the inputs of an EOF
+// code section, so that they can be referred to by reaching definitions.
+func (self *Program) createInputs() {
+	inputs := self.Container.Types[self.Section].Inputs
+	if inputs == 0 {
+		return
+	}
+	self.Inputs = &BasicBlock{
+		Offset:      -1,
+		Annotations: NewTypeMap(),
+	}
+	for i := 0; i < inputs; i++ {
+		inst := Instruction{
+			Op:          CALLF,
+			Annotations: NewTypeMap(),
+		}
+		var expression Expression = &InputExpression{i}
+		inst.Annotations.Set(&expression)
+		self.Inputs.Instructions = append(self.Inputs.Instructions, inst)
+	}
+}
+
+// Callees returns the indexes of the code sections called or jumped to from the
+// given section with CALLF or JUMPF.
+func (self *Container) Callees(section int) []int {
+	var callees []int
+	seen := make(map[int]bool)
+	for _, block := range self.Sections[section].Blocks {
+		for _, inst := range block.Instructions {
+			if (inst.Op == CALLF || inst.Op == JUMPF) && !seen[int(inst.Arg.Int64())] {
+				seen[int(inst.Arg.Int64())] = true
+				callees = append(callees, int(inst.Arg.Int64()))
+			}
+		}
+	}
+	return callees
+}
+
+// functionType returns the type of the code section with the given index.
+func (self *Program) functionType(section int64) (FunctionType, bool) {
+	if self.Container == nil || section < 0 || section >= int64(len(self.Container.Types)) {
+		return FunctionType{}, false
+	}
+	return self.Container.Types[section], true
+}
+
+// exchangeDepths returns the depths below the top of the stack of the two items
+// an EXCHANGE swaps.
+func exchangeDepths(inst *Instruction) (int, int) {
+	imm := int(inst.Arg.Int64())
+	n, m := imm>>4+1, imm&0x0f+1
+	return n, n + m
+}
+
+// StackReads returns the number of stack items inst reads. For most
+// instructions this depends only on the opcode, but the EOF function call
+// instructions depend on the type of the code section involved, and DUPN,
+// SWAPN and EXCHANGE on their immediates.
+func (self *Program) StackReads(inst *Instruction) int {
+	switch inst.Op {
+	case DUPN:
+		return int(inst.Arg.Int64()) + 1
+	case SWAPN:
+		return int(inst.Arg.Int64()) + 2
+	case EXCHANGE:
+		_, depth := exchangeDepths(inst)
+		return depth + 1
+	case CALLF, JUMPF:
+		if callee, ok := self.functionType(inst.Arg.Int64()); ok {
+			return callee.Inputs
+		}
+	case RETF:
+		if current, ok := self.functionType(int64(self.Section)); ok {
+			return current.Outputs
+		}
+	}
+	return inst.Op.StackReads()
+}
+
+// StackWrites returns the number of stack items inst writes; see StackReads.
+func (self *Program) StackWrites(inst *Instruction) int {
+	switch inst.Op {
+	case CALLF:
+		if callee, ok := self.functionType(inst.Arg.Int64()); ok {
+			return callee.Outputs
+		}
+	case DUPN:
+		return int(inst.Arg.Int64()) + 2
+	case SWAPN, EXCHANGE:
+		return self.StackReads(inst)
+	}
+	return inst.Op.StackWrites()
+}
+
+// immediateArguments returns expressions for the immediate arguments of EOF
+// instructions, which are shown ahead of their stack arguments.
+func immediateArguments(block *BasicBlock, inst *Instruction) []Expression {
+	switch inst.Op {
+	case RJUMP, RJUMPI, RJUMPV:
+		args := make([]Expression, 0, len(block.StaticTargets))
+		for _, target := range block.StaticTargets {
+			var label *JumpLabel
+			target.Annotations.Get(&label)
+			if label != nil {
+				args = append(args, label)
+			}
+		}
+		return args
+	case CALLF, JUMPF:
+		return []Expression{&SectionLabel{int(inst.Arg.Int64())}}
+	case DATALOADN, EOFCREATE, RETURNCONTRACT:
+		return []Expression{&ImmediateExpression{inst.Arg}}
+	}
+	return nil
+}
+
+// SectionLabel refers to an EOF code section, as the target of CALLF or JUMPF.
+type SectionLabel struct {
+	Index int
+}
+
+func (self *SectionLabel) Eval() *big.Int {
+	return big.NewInt(int64(self.Index))
+}
+
+func (self *SectionLabel) String() string {
+	return fmt.Sprintf(":section%d", self.Index)
+}
+
+// ImmediateExpression is an immediate argument of an instruction.
+type ImmediateExpression struct {
+	Value *big.Int
+}
+
+func (self *ImmediateExpression) Eval() *big.Int {
+	return self.Value
+}
+
+func (self *ImmediateExpression) String() string {
+	return fmt.Sprintf("0x%X", self.Value)
+}
+
+// InputExpression is one of the inputs of an EOF code section.
+type InputExpression struct {
+	Index int
+}
+
+func (self *InputExpression) Eval() *big.Int {
+	return nil
+}
+
+func (self *InputExpression) String() string {
+	return fmt.Sprintf("input%d", self.Index)
+}
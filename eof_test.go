@@ -0,0 +1,70 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// eofContainer returns a container with a single code section, of the given
+// type, and an empty data section.
+func eofContainer(functionType, code string) string {
+	return fmt.Sprintf("ef0001010004020001%04x04000000%v%v", len(code)/2, functionType, code)
+}
+
+func TestParseContainer(t *testing.T) {
+	// Pushes three values, reorders them with DUPN, SWAPN and EXCHANGE, and
+	// pops them
+	code := "600160026003e601e700e8015050505000"
+	bytecode, _ := hex.DecodeString(eofContainer("00800004", code))
+	container, err := ParseContainer(bytecode, LatestFork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Version != 1 || len(container.Sections) != 1 || len(container.Data) != 0 {
+		t.Fatalf("unexpected container %+v", container)
+	}
+	if want := (FunctionType{0, 0, true, 4}); container.Types[0] != want {
+		t.Errorf("got type %+v, want %+v", container.Types[0], want)
+	}
+	var ops []string
+	for _, block := range container.Sections[0].Blocks {
+		for _, inst := range block.Instructions {
+			ops = append(ops, inst.Op.String())
+		}
+	}
+	if got := strings.Join(ops, " "); got != "PUSH1 PUSH1 PUSH1 DUPN SWAPN EXCHANGE POP POP POP POP STOP" {
+		t.Errorf("got instructions %v", got)
+	}
+
+	for _, test := range []struct {
+		name      string
+		container string
+		err       string
+	}{
+		{"legacy", "6000", "EOF magic"},
+		{"version", "ef0002", "version 2"},
+		{"types size", "ef000101000802000100010400000000800000" + "00", "type section size 8"},
+		{"terminator", "ef000101000402000100010400000100800000" + "00", "section kind 0x00"},
+		{"truncated body", "ef00010100040200010002040000000080000000", "Unexpected end"},
+		{"truncated immediate", eofContainer("00800001", "60"), "Truncated immediate for PUSH1"},
+		{"jump into immediate", eofContainer("00800001", "e00001600000"), "Invalid target 0x4 for RJUMP"},
+		{"jump out of section", eofContainer("00800000", "e0001000"), "Invalid target 0x13 for RJUMP"},
+	} {
+		bytecode, _ := hex.DecodeString(test.container)
+		if _, err := ParseContainer(bytecode, LatestFork); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	bytecode, _ = hex.DecodeString(eofContainer("00800000", "e0000000"))
+	container, err = ParseContainer(bytecode, LatestFork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := container.Sections[0].Blocks
+	if len(blocks) != 2 || len(blocks[0].StaticTargets) != 1 || blocks[0].StaticTargets[0] != blocks[1] {
+		t.Errorf("RJUMP doesn't target the following STOP")
+	}
+}
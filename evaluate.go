@@ -0,0 +1,191 @@
+package evmdis
+
+import (
+	"fmt"
+	"math/big"
+)
+
+var (
+	wordMask = new(big.Int).Sub(wordModulus, big.NewInt(1))
+	// Smallest negative word, -2 ** 255, as an unsigned value
+	signBit = new(big.Int).Lsh(big.NewInt(1), 255)
+)
+
+// toSigned interprets a word as a two's complement signed integer.
+func toSigned(value *big.Int) *big.Int {
+	if value.Cmp(signBit) >= 0 {
+		return new(big.Int).Sub(value, wordModulus)
+	}
+	return value
+}
+
+// toWord reduces a value to a word, wrapping as the EVM does.
+func toWord(value *big.Int) *big.Int {
+	return value.And(value, wordMask)
+}
+
+func boolWord(value bool) *big.Int {
+	if value {
+		return big.NewInt(1)
+	}
+	return big.NewInt(0)
+}
+
+// EvalOp applies op to constant arguments with the EVM's 256-bit semantics,
+// returning nil for operations that don't only depend on their arguments. Args
+// are in the order they're popped from the stack, and must be words.
+func EvalOp(op OpCode, args []*big.Int) *big.Int {
+	if len(args) != op.StackReads() {
+		return nil
+	}
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+	}
+
+	result := new(big.Int)
+	switch op {
+	case ADD:
+		result.Add(args[0], args[1])
+	case SUB:
+		result.Sub(args[0], args[1])
+	case MUL:
+		result.Mul(args[0], args[1])
+	case DIV:
+		if args[1].Sign() != 0 {
+			result.Div(args[0], args[1])
+		}
+	case SDIV:
+		if args[1].Sign() != 0 {
+			// Go's Quo truncates towards zero, as SDIV does
+			result.Quo(toSigned(args[0]), toSigned(args[1]))
+		}
+	case MOD:
+		if args[1].Sign() != 0 {
+			result.Mod(args[0], args[1])
+		}
+	case SMOD:
+		if args[1].Sign() != 0 {
+			// The result takes the sign of the dividend, as Rem's does
+			result.Rem(toSigned(args[0]), toSigned(args[1]))
+		}
+	case ADDMOD:
+		if args[2].Sign() != 0 {
+			result.Add(args[0], args[1])
+			result.Mod(result, args[2])
+		}
+	case MULMOD:
+		if args[2].Sign() != 0 {
+			result.Mul(args[0], args[1])
+			result.Mod(result, args[2])
+		}
+	case EXP:
+		result.Exp(args[0], args[1], wordModulus)
+	case SIGNEXTEND:
+		result.Set(args[1])
+		if args[0].Cmp(big.NewInt(31)) < 0 {
+			bit := uint(args[0].Uint64()*8 + 7)
+			if args[1].Bit(int(bit)) == 1 {
+				// Set every bit above the sign bit
+				mask := new(big.Int).Lsh(wordMask, bit)
+				result.Or(result, mask)
+			} else {
+				mask := new(big.Int).Lsh(big.NewInt(1), bit)
+				result.And(result, mask.Sub(mask, big.NewInt(1)))
+			}
+		}
+	case LT:
+		result = boolWord(args[0].Cmp(args[1]) < 0)
+	case GT:
+		result = boolWord(args[0].Cmp(args[1]) > 0)
+	case SLT:
+		result = boolWord(toSigned(args[0]).Cmp(toSigned(args[1])) < 0)
+	case SGT:
+		result = boolWord(toSigned(args[0]).Cmp(toSigned(args[1])) > 0)
+	case EQ:
+		result = boolWord(args[0].Cmp(args[1]) == 0)
+	case ISZERO:
+		result = boolWord(args[0].Sign() == 0)
+	case AND:
+		result.And(args[0], args[1])
+	case OR:
+		result.Or(args[0], args[1])
+	case XOR:
+		result.Xor(args[0], args[1])
+	case NOT:
+		result.Xor(args[0], wordMask)
+	case BYTE:
+		if args[0].Cmp(big.NewInt(32)) < 0 {
+			result.Rsh(args[1], uint(31-args[0].Uint64())*8)
+			result.And(result, big.NewInt(0xFF))
+		}
+	case SHL:
+		if args[0].Cmp(big.NewInt(256)) < 0 {
+			result.Lsh(args[1], uint(args[0].Uint64()))
+		}
+	case SHR:
+		if args[0].Cmp(big.NewInt(256)) < 0 {
+			result.Rsh(args[1], uint(args[0].Uint64()))
+		}
+	case SAR:
+		// Rsh rounds towards negative infinity, as SAR does
+		shift := uint(255)
+		if args[0].Cmp(big.NewInt(255)) < 0 {
+			shift = uint(args[0].Uint64())
+		}
+		result.Rsh(toSigned(args[1]), shift)
+	default:
+		return nil
+	}
+	return toWord(result)
+}
+
+// ConstantExpression is a constant subexpression that FoldConstants has
+// replaced with its value.
+type ConstantExpression struct {
+	Value *big.Int
+	// Expression the value was computed from
+	Folded Expression
+}
+
+func (self *ConstantExpression) String() string {
+	return fmt.Sprintf("0x%X", self.Value)
+}
+
+func (self *ConstantExpression) Eval() *big.Int {
+	return self.Value
+}
+
+// foldExpression returns an expression with each of its constant
+// subexpressions replaced by a ConstantExpression.
+func foldExpression(expression Expression) Expression {
+	ie, ok := expression.(*InstructionExpression)
+	if !ok || ie.Inst.Op.IsPush() {
+		return expression
+	}
+	if value := ie.Eval(); value != nil {
+		return &ConstantExpression{value, ie}
+	}
+	for i, arg := range ie.Arguments {
+		ie.Arguments[i] = foldExpression(arg)
+	}
+	return ie
+}
+
+// FoldConstants replaces the subexpressions of each instruction's Expression
+// that only depend on constants, such as `0x2 ** 0xE0`, with their values.
+// Expressions must already have been built.
+func FoldConstants(prog *Program) {
+	for _, block := range prog.Blocks {
+		for i := range block.Instructions {
+			inst := &block.Instructions[i]
+			var expression Expression
+			inst.Annotations.Get(&expression)
+			if expression != nil {
+				expression = foldExpression(expression)
+				inst.Annotations.Set(&expression)
+			}
+		}
+	}
+}
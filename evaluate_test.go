@@ -0,0 +1,95 @@
+package evmdis
+
+import (
+	"math/big"
+	"testing"
+)
+
+// word parses a hex constant, treating a leading minus sign as a two's
+// complement negative word.
+func word(t *testing.T, s string) *big.Int {
+	negative := s[0] == '-'
+	if negative {
+		s = s[1:]
+	}
+	value, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		t.Fatalf("invalid constant %v", s)
+	}
+	if negative {
+		value.Sub(wordModulus, value)
+	}
+	return value
+}
+
+func TestEvalOp(t *testing.T) {
+	max := "0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+	min := "0x8000000000000000000000000000000000000000000000000000000000000000"
+	for _, test := range []struct {
+		op     OpCode
+		args   []string
+		result string
+	}{
+		{ADD, []string{max, "2"}, "1"},
+		{SUB, []string{"1", "2"}, max},
+		{MUL, []string{min, "2"}, "0"},
+		{DIV, []string{"7", "2"}, "3"},
+		{DIV, []string{"7", "0"}, "0"},
+		{MOD, []string{"7", "0"}, "0"},
+		{SDIV, []string{"-8", "3"}, "-2"},
+		{SDIV, []string{min, "-1"}, min},
+		{SDIV, []string{"-8", "0"}, "0"},
+		{SMOD, []string{"-7", "3"}, "-1"},
+		{SMOD, []string{"7", "-3"}, "1"},
+		{ADDMOD, []string{max, "2", max}, "2"},
+		{ADDMOD, []string{"1", "2", "0"}, "0"},
+		{MULMOD, []string{max, max, "12"}, "9"},
+		{EXP, []string{"2", "255"}, min},
+		{EXP, []string{"2", "256"}, "0"},
+		{EXP, []string{"0", "0"}, "1"},
+		{SIGNEXTEND, []string{"0", "0xff"}, max},
+		{SIGNEXTEND, []string{"0", "0x17f"}, "0x7f"},
+		{SIGNEXTEND, []string{"31", "0x17f"}, "0x17f"},
+		{LT, []string{"1", max}, "1"},
+		{SLT, []string{"1", max}, "0"},
+		{SGT, []string{"1", "-1"}, "1"},
+		{EQ, []string{"3", "3"}, "1"},
+		{ISZERO, []string{"3"}, "0"},
+		{NOT, []string{"0"}, max},
+		{BYTE, []string{"31", "0x1234"}, "0x34"},
+		{BYTE, []string{"30", "0x1234"}, "0x12"},
+		{BYTE, []string{"32", "0x1234"}, "0"},
+		{SHL, []string{"255", "3"}, min},
+		{SHL, []string{"256", "1"}, "0"},
+		{SHR, []string{"4", "0x1234"}, "0x123"},
+		{SHR, []string{"256", max}, "0"},
+		{SAR, []string{"1", "-2"}, "-1"},
+		{SAR, []string{"1", "-3"}, "-2"},
+		{SAR, []string{"300", "-1"}, max},
+		{SAR, []string{"300", "5"}, "0"},
+	} {
+		var args []*big.Int
+		for _, arg := range test.args {
+			args = append(args, word(t, arg))
+		}
+		want := word(t, test.result)
+		if got := EvalOp(test.op, args); got == nil || got.Cmp(want) != 0 {
+			t.Errorf("%v%v: got %v, want %v", test.op, test.args, got, want)
+		}
+	}
+
+	// Operations that can't be evaluated from their arguments alone
+	for _, test := range []struct {
+		op   OpCode
+		args []*big.Int
+	}{
+		{CALLER, nil},
+		{SLOAD, []*big.Int{big.NewInt(0)}},
+		{ADD, []*big.Int{big.NewInt(1)}},
+		{ADD, []*big.Int{big.NewInt(1), nil}},
+	} {
+		if got := EvalOp(test.op, test.args); got != nil {
+			t.Errorf("%v%v: got %v, want nil", test.op, test.args, got)
+		}
+	}
+}
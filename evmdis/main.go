@@ -2,30 +2,81 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/Arachnid/evmdis"
+	"github.com/Arachnid/evmdis/signatures"
 )
 
-const swarmHashLength = 43
-
-var swarmHashProgramTrailer = [...]byte{0x00, 0x29}
-var swarmHashHeader = [...]byte{0xa1, 0x65}
-
 func main() {
+	defaults := evmdis.DefaultOptions()
 
-	withSwarmHash := flag.Bool("swarm", true, "solc adds a reference to the Swarm API description to the generated bytecode, if this flag is set it removes this reference before analysis")
+	withSwarmHash := flag.Bool("swarm", defaults.StripMetadata, "solc and Vyper append CBOR encoded metadata (such as the Swarm or IPFS hash of the contract's metadata file) to the generated bytecode, if this flag is set it removes this metadata before analysis")
 	ctorMode := flag.Bool("ctor", false, "Indicates that the provided bytecode has construction(ctor) code included. (needs to be analyzed separately)")
 	logging := flag.Bool("log", false, "print logging output")
 	binary := flag.Bool("bin", false, "read binary file")
+	forkName := flag.String("fork", defaults.Fork.String(), "hard fork whose opcode set is used to decode the bytecode")
+	strict := flag.Bool("strict", false, "exit with a non-zero status if any analysis reports an error")
+	format := flag.String("format", defaults.Format, "output format: text, dot for a Graphviz control flow graph, pseudo for structured pseudo-code, or json")
+	names := flag.Bool("names", true, "name function selectors and event topics using the built-in signature database and any signature files")
+	fold := flag.Bool("fold", false, "replace constant subexpressions, such as 0x2 ** 0xE0, with their values")
+	liveness := flag.Bool("liveness", false, "find the definitions on each block's stack whose values are still to be used, which the JSON output includes")
+	contextDepth := flag.Int("context", 0, "number of return addresses on the stack that distinguish calling contexts in the reaching analysis; if non-zero, the stack of blocks reached in several contexts is also shown for each")
+	maxStates := flag.Int("max-states", defaults.Reaching.Execution.MaxStates, "maximum number of states the reaching analysis explores before giving up with incomplete results; 0 for no limit")
+	timeout := flag.Duration("timeout", defaults.Reaching.Execution.Timeout, "maximum time the reaching analysis may take before giving up with incomplete results; 0 for no limit")
+	widenAfter := flag.Int("widen", defaults.Reaching.Execution.WidenAfter, "number of distinct stacks a block may be reached with before further ones are joined into them; 0 to never join")
+	signatureFiles := flag.String("signatures", "", "comma separated list of files of additional function and event signatures, one per line")
 
 	flag.Parse()
 
+	validFormat := false
+	for _, name := range evmdis.Formats {
+		validFormat = validFormat || name == *format
+	}
+	if !validFormat {
+		panic(fmt.Sprintf("Invalid format: %v", *format))
+	}
+
+	fork, err := evmdis.ParseFork(*forkName)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid fork: %v", err))
+	}
+
+	options := defaults
+	options.StripMetadata = *withSwarmHash
+	options.Constructor = *ctorMode
+	options.Fork = fork
+	options.Format = *format
+	options.FoldConstants = *fold
+	options.Liveness = *liveness
+	options.Reaching.ContextDepth = *contextDepth
+	options.Reaching.Execution = evmdis.ExecutionOptions{
+		MaxStates:  *maxStates,
+		Timeout:    *timeout,
+		WidenAfter: *widenAfter,
+	}
+
+	options.Resolver = nil
+	if *names {
+		db := signatures.Builtin()
+		if *signatureFiles != "" {
+			for _, path := range strings.Split(*signatureFiles, ",") {
+				if err := db.LoadFile(path); err != nil {
+					panic(fmt.Sprintf("Could not load signatures: %v", err))
+				}
+			}
+		}
+		options.Resolver = db
+	}
+
 	if !*logging {
 		log.SetOutput(ioutil.Discard)
 	}
@@ -51,129 +102,22 @@ func main() {
 		}
 	}
 
-	if disassembly, err := Disassemble(bytecode, *withSwarmHash, *ctorMode); err != nil {
-		panic(fmt.Sprintf("Unable to disassemble: %v", err))
-	} else {
-		fmt.Println(disassembly)
-	}
-}
-
-func Disassemble(bytecode []byte, withSwarmHash bool, ctorMode bool) (disassembly string, err error) {
-	// detect swarm hash and remove it from bytecode, see http://solidity.readthedocs.io/en/latest/miscellaneous.html?highlight=swarm#encoding-of-the-metadata-hash-in-the-bytecode
-	bytecodeLength := uint64(len(bytecode))
-	if bytecode[bytecodeLength-1] == swarmHashProgramTrailer[1] &&
-		bytecode[bytecodeLength-2] == swarmHashProgramTrailer[0] &&
-		bytecode[bytecodeLength-43] == swarmHashHeader[0] &&
-		bytecode[bytecodeLength-42] == swarmHashHeader[1] &&
-		withSwarmHash {
-
-		bytecodeLength -= swarmHashLength // remove swarm part
-	}
-
-	program := evmdis.NewProgram(bytecode[:bytecodeLength])
-	AnalyzeProgram(program)
-
-	if ctorMode {
-		var codeEntryPoint = FindNextCodeEntryPoint(program)
+	// Print what's been found so far if interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-		if codeEntryPoint == 0 {
-			return disassembly, fmt.Errorf("no code entrypoint found in ctor")
-		} else if codeEntryPoint >= bytecodeLength {
-			return disassembly, fmt.Errorf("code entrypoint outside of currently available code")
-		}
-
-		ctor := evmdis.NewProgram(bytecode[:codeEntryPoint])
-		code := evmdis.NewProgram(bytecode[codeEntryPoint:bytecodeLength])
-
-		AnalyzeProgram(ctor)
-		disassembly += fmt.Sprintln("# Constructor part -------------------------")
-		disassembly += PrintAnalysisResult(ctor)
-
-		AnalyzeProgram(code)
-		disassembly += fmt.Sprintln("# Code part -------------------------")
-		disassembly += PrintAnalysisResult(code)
-
-	} else {
-		disassembly += PrintAnalysisResult(program)
-	}
-
-	return disassembly, nil
-}
-
-func FindNextCodeEntryPoint(program *evmdis.Program) uint64 {
-	var lastPos uint64 = 0
-	for _, block := range program.Blocks {
-		for _, instruction := range block.Instructions {
-			if instruction.Op == evmdis.CODECOPY {
-				var expression evmdis.Expression
-
-				instruction.Annotations.Get(&expression)
-
-				arg := expression.(*evmdis.InstructionExpression).Arguments[1].Eval()
-
-				if arg != nil {
-					lastPos = arg.Uint64()
-				}
-			}
-		}
-	}
-	return lastPos
-}
-
-func PrintAnalysisResult(program *evmdis.Program) (disassembly string) {
-	for _, block := range program.Blocks {
-		offset := block.Offset
-
-		// Print out the jump label for the block, if there is one
-		var label *evmdis.JumpLabel
-		block.Annotations.Get(&label)
-		if label != nil {
-			disassembly += fmt.Sprintf("%v\n", label)
-		}
-
-		// Print out the stack prestate for this block
-		var reaching evmdis.ReachingDefinition
-		block.Annotations.Get(&reaching)
-
-		blockDisassembly := fmt.Sprintf("# Stack: %v\n", reaching)
-		blockRealInstructions := 0
-
-		for _, instruction := range block.Instructions {
-			var expression evmdis.Expression
-			instruction.Annotations.Get(&expression)
-
-			if expression != nil {
-				if instruction.Op.StackWrites() == 1 && !instruction.Op.IsDup() {
-					blockDisassembly += fmt.Sprintf("0x%X\tPUSH(%v)\n", offset, expression)
-				} else {
-					blockDisassembly += fmt.Sprintf("0x%X\t%v\n", offset, expression)
-				}
-
-				blockRealInstructions++
-			}
-			offset += instruction.Op.OperandSize() + 1
-		}
-
-		blockDisassembly += fmt.Sprintf("\n")
-
-		// avoid printing empty stack frames with no instructions in the block
-		if len(reaching) > 0 || blockRealInstructions > 0 {
-			disassembly += blockDisassembly
-		}
+	result, err := evmdis.AnalyzeContext(ctx, bytecode, options)
+	if result == nil {
+		panic(fmt.Sprintf("Unable to disassemble: %v", err))
 	}
+	fmt.Println(result.Output)
 
-	return disassembly
-}
-
-func AnalyzeProgram(program *evmdis.Program) (err error) {
-	if err := evmdis.PerformReachingAnalysis(program); err != nil {
-		return fmt.Errorf("Error performing reaching analysis: %v", err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Analysis interrupted: %v\n", err)
+		os.Exit(1)
 	}
-	evmdis.PerformReachesAnalysis(program)
-	evmdis.CreateLabels(program)
-	if err := evmdis.BuildExpressions(program); err != nil {
-		return fmt.Errorf("Error building expressions: %v", err)
+	if *strict && result.Diagnostics.HasErrors() {
+		fmt.Fprintln(os.Stderr, "Analysis reported errors")
+		os.Exit(1)
 	}
-
-	return nil
 }
@@ -1,6 +1,7 @@
 package evmdis
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"strings"
@@ -50,12 +51,23 @@ type InstructionExpression struct {
 	Arguments []Expression
 }
 
+// Eval returns the value of the expression if it's constant, evaluating
+// arithmetic over pushed values, or nil otherwise.
 func (self *InstructionExpression) Eval() *big.Int {
-	return self.Inst.Arg
+	if self.Inst.Op.IsPush() {
+		return self.Inst.Arg
+	}
+	args := make([]*big.Int, 0, len(self.Arguments))
+	for _, arg := range self.Arguments {
+		args = append(args, arg.Eval())
+	}
+	return EvalOp(self.Inst.Op, args)
 }
 
 func (self *InstructionExpression) String() string {
-	if self.Inst.Op.IsPush() {
+	if self.Inst.Undefined {
+		return self.Inst.String()
+	} else if self.Inst.Op.IsPush() {
 		// Print push instructions as just their value
 		return fmt.Sprintf("0x%X", self.Inst.Arg)
 	} else if format, ok := opcodeFormatStrings[self.Inst.Op]; ok {
@@ -80,11 +92,20 @@ func (self *InstructionExpression) String() string {
 
 type PopExpression struct{
 	Inst      *InstructionPointer
+	// If several definitions reach, but only one in each calling context,
+	// the definitions
+	Contexts []InstructionPointer
 }
 
 func (self *PopExpression) String() string {
 	if self.Inst != nil {
 		return "POP("+self.Inst.String()+")"
+	} else if len(self.Contexts) > 0 {
+		definitions := make([]string, 0, len(self.Contexts))
+		for _, ptr := range self.Contexts {
+			definitions = append(definitions, ptr.String())
+		}
+		return "POP("+strings.Join(definitions, " | ")+")"
 	} else {
 		return "POP()"
 	}
@@ -106,6 +127,19 @@ func (self *SwapExpression) Eval() *big.Int {
 	return nil
 }
 
+// ExchangeExpression swaps the items at two depths below the top of the stack.
+type ExchangeExpression struct {
+	first, second int
+}
+
+func (self *ExchangeExpression) String() string {
+	return fmt.Sprintf("EXCHANGE(%d, %d)", self.first, self.second)
+}
+
+func (self *ExchangeExpression) Eval() *big.Int {
+	return nil
+}
+
 type DupExpression struct {
 	count int
 }
@@ -178,6 +212,15 @@ func CreateLabels(prog *Program) {
 		}
 	}
 
+	// Static jumps in EOF code refer to their targets directly
+	for _, block := range prog.Blocks {
+		for _, target := range block.StaticTargets {
+			var label *JumpLabel
+			target.Annotations.Get(&label)
+			label.refCount += 1
+		}
+	}
+
 	// Assign label numbers and delete unused labels
 	count := 0
 	for _, block := range prog.Blocks {
@@ -192,8 +235,20 @@ func CreateLabels(prog *Program) {
 	}
 }
 
+// BuildExpressions builds an Expression for each reachable instruction. Blocks
+// it can't make sense of are left partially processed, and the problem is
+// recorded in the program's Diagnostics.
 func BuildExpressions(prog *Program) error {
+	return BuildExpressionsContext(context.Background(), prog)
+}
+
+// BuildExpressionsContext is BuildExpressions, stopping early with ctx.Err() if
+// ctx is cancelled. Blocks processed until then keep their expressions.
+func BuildExpressionsContext(ctx context.Context, prog *Program) error {
 	for _, block := range prog.Blocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		var reaching ReachingDefinition
 		block.Annotations.Get(&reaching)
 
@@ -205,14 +260,23 @@ func BuildExpressions(prog *Program) error {
 		// Lifted is a set of subexpressions that can be incorporated into larger expressions;
 		// they have been 'lifted' out of the stack.
 		lifted := make(InstructionPointerSet)
+		truncated := false
 		for i := 0; i < len(block.Instructions); i++ {
 			inst := &block.Instructions[i]
 
 			// Find all the definitions that reach each argument of this op
 			var reaching ReachingDefinition
 			inst.Annotations.Get(&reaching)
-			if len(reaching) != inst.Op.StackReads() {
-				return fmt.Errorf("Processing %v@0x%X: expected number of stack reads (%v) to equal reaching definition length (%v)", inst, block.OffsetOf(inst), inst.Op.StackReads(), len(reaching))
+			if reaching == nil {
+				// Execution never got this far through the block; the
+				// reaching analysis will have recorded why.
+				truncated = true
+				break
+			}
+			if len(reaching) != prog.StackReads(inst) {
+				prog.Diagnostics.Errorf(AnalysisExpressions, block, block.OffsetOf(inst), "Expected number of stack reads (%v) by %v to equal reaching definition length (%v)", prog.StackReads(inst), inst, len(reaching))
+				truncated = true
+				break
 			}
 
 			if inst.Op.IsSwap() {
@@ -239,6 +303,17 @@ func BuildExpressions(prog *Program) error {
 				}
 				var expression Expression = &SwapExpression{count + 1}
 				inst.Annotations.Set(&expression)				
+			} else if inst.Op == EXCHANGE {
+				// Leave the items involved on the stack, so their depths stay
+				// as they are
+				for _, pointers := range reaching {
+					if len(pointers) == 1 {
+						delete(lifted, *pointers.First())
+					}
+				}
+				first, second := exchangeDepths(inst)
+				var expression Expression = &ExchangeExpression{first, second}
+				inst.Annotations.Set(&expression)
 			} else if inst.Op.IsDup() {
 				// Try and reduce the size of dup operations to account for lifted arguments
 
@@ -268,9 +343,9 @@ func BuildExpressions(prog *Program) error {
 
 				// Don't recalculate expressions found by previous passes
 				if expression == nil {
-					args := make([]Expression, 0, inst.Op.StackReads())
+					args := immediateArguments(block, inst)
 					// Assemble a subexpression for each argument
-					for _, pointers := range reaching {
+					for j, pointers := range reaching {
 						if len(pointers) > 1 || !lifted[*pointers.First()] {
 							// If there's more than one definition reaching the argument
 							// or it's not in our set of expression fragments, represent it
@@ -278,6 +353,10 @@ func BuildExpressions(prog *Program) error {
 							var expression = &PopExpression{}
 							if len(pointers) == 1 {
 								expression.Inst = pointers.First()
+							} else {
+								// Such as the return address of an internal function,
+								// which is known in each context it's called from
+								expression.Contexts = contextDefinitions(inst, j)
 							}
 							var converted = Expression(expression)
 							args = append(args, converted)
@@ -292,6 +371,12 @@ func BuildExpressions(prog *Program) error {
 					}
 
 					expression = &InstructionExpression{inst, args}
+					// Jumps to internal functions are shown as calls
+					var call *InternalCall
+					inst.Annotations.Get(&call)
+					if call != nil && !call.Fallthrough {
+						expression = &CallExpression{call}
+					}
 					inst.Annotations.Set(&expression)
 				}
 
@@ -305,8 +390,8 @@ func BuildExpressions(prog *Program) error {
 				}
 			}
 		}
-		if len(lifted) != 0 {
-			return fmt.Errorf("Expected all lifted arguments to be consumed by end of block: %v", lifted)
+		if len(lifted) != 0 && !truncated {
+			prog.Diagnostics.Errorf(AnalysisExpressions, block, block.Offset, "Expected all lifted arguments to be consumed by end of block: %v", lifted)
 		}
 	}
 
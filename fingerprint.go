@@ -0,0 +1,252 @@
+package evmdis
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	CompilerUnknown = "unknown"
+	CompilerSolc    = "solc"
+	CompilerVyper   = "vyper"
+	CompilerHuff    = "huff"
+)
+
+// Code generation pipelines of solc
+const (
+	PipelineLegacy = "legacy"
+	PipelineIR     = "via-ir"
+)
+
+// Fingerprint is a best guess at the compiler that produced a program.
+type Fingerprint struct {
+	Compiler string
+	// Range of compiler versions consistent with the evidence; MinVersion is
+	// inclusive and MaxVersion exclusive, and either is "" if unbounded.
+	MinVersion string
+	MaxVersion string
+	// For solc, whether the code came from the legacy code generator or the
+	// via-IR pipeline, or "" if that can't be told
+	Pipeline string
+	// Human readable descriptions of what the guess is based on
+	Evidence []string
+}
+
+func (self *Fingerprint) String() string {
+	parts := []string{self.Compiler}
+	if self.MinVersion != "" && self.MinVersion == self.MaxVersion {
+		parts = append(parts, self.MinVersion)
+	} else {
+		if self.MinVersion != "" {
+			parts = append(parts, ">="+self.MinVersion)
+		}
+		if self.MaxVersion != "" {
+			parts = append(parts, "<"+self.MaxVersion)
+		}
+	}
+	if self.Pipeline != "" {
+		parts = append(parts, self.Pipeline)
+	}
+	if len(self.Evidence) > 0 {
+		parts = append(parts, fmt.Sprintf("(%v)", strings.Join(self.Evidence, ", ")))
+	}
+	return strings.Join(parts, " ")
+}
+
+// compareVersions compares two dotted version numbers, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var x, y int
+		if i < len(partsA) {
+			x, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			y, _ = strconv.Atoi(partsB[i])
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// narrow restricts the version range to [min, max), recording the evidence for
+// doing so. Either bound may be "".
+func (self *Fingerprint) narrow(min, max, evidence string) {
+	if min != "" && (self.MinVersion == "" || compareVersions(min, self.MinVersion) > 0) {
+		self.MinVersion = min
+	}
+	if max != "" && (self.MaxVersion == "" || compareVersions(max, self.MaxVersion) < 0) {
+		self.MaxVersion = max
+	}
+	self.Evidence = append(self.Evidence, evidence)
+}
+
+var (
+	// Selector of Panic(uint256), used by solc >= 0.8.0 for failed checks
+	panicSelector = big.NewInt(0x4e487b71)
+	// Selector of Error(string), used for revert reasons
+	errorSelector = big.NewInt(0x08c379a0)
+)
+
+// isSelectorConstant returns true if value is selector, either on its own or
+// shifted into the most significant bytes of a word.
+func isSelectorConstant(value, selector *big.Int) bool {
+	return value.Cmp(selector) == 0 || value.Cmp(new(big.Int).Lsh(selector, 224)) == 0
+}
+
+// matchOps returns true if the instructions starting at insts[i] have the given
+// opcodes and, for those where args is non-nil, the given arguments.
+func matchOps(insts []*Instruction, i int, ops []OpCode, args []int64) bool {
+	if i+len(ops) > len(insts) {
+		return false
+	}
+	for j, op := range ops {
+		inst := insts[i+j]
+		if op == PUSH1 && inst.Op.IsPush() {
+			// Match pushes of any size
+		} else if inst.Op != op {
+			return false
+		}
+		if args != nil && args[j] >= 0 && (inst.Arg == nil || inst.Arg.Cmp(big.NewInt(args[j])) != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// FingerprintProgram guesses the compiler and range of compiler versions that
+// produced a program. It uses the program's metadata where available, and
+// otherwise heuristics based on idioms each compiler is known to emit. Pushes
+// of any width are treated alike, so PUSH1 in the patterns below matches any
+// PUSH.
+func FingerprintProgram(prog *Program) *Fingerprint {
+	fingerprint := &Fingerprint{Compiler: CompilerUnknown}
+
+	if prog.Metadata != nil && prog.Metadata.Compiler != "" {
+		fingerprint.Compiler = prog.Metadata.Compiler
+		version := prog.Metadata.CompilerVersion
+		if i := strings.IndexAny(version, "-+"); i >= 0 {
+			version = version[:i]
+		}
+		fingerprint.MinVersion, fingerprint.MaxVersion = version, version
+		fingerprint.Evidence = append(fingerprint.Evidence, "metadata")
+		return fingerprint
+	}
+
+	// Code a constructor copies out, such as the runtime code, was produced by
+	// the same compiler
+	blocks := prog.Blocks
+	for _, region := range prog.DataRegions {
+		if region.Reason == DataReasonCodeCopy {
+			blocks = append(blocks, decodeProgram(region.Data, prog.Fork).Blocks...)
+		}
+	}
+	var insts []*Instruction
+	for _, block := range blocks {
+		for i := range block.Instructions {
+			insts = append(insts, &block.Instructions[i])
+		}
+	}
+
+	var freeMemoryPointer, shrSelector, divSelector, vyperSelector, earlySelector bool
+	var panics, errorStrings, push0, mcopy, revert bool
+	var legacyCallValue, irCallValue bool
+	for i, inst := range insts {
+		switch {
+		case inst.Op.IsPush() && isSelectorConstant(inst.Arg, panicSelector):
+			panics = true
+		case inst.Op.IsPush() && isSelectorConstant(inst.Arg, errorSelector):
+			errorStrings = true
+		case inst.Op == PUSH0:
+			push0 = true
+		case inst.Op == MCOPY:
+			mcopy = true
+		case inst.Op == REVERT:
+			revert = true
+		}
+
+		// CALLDATALOAD(0) >> 0xE0
+		if matchOps(insts, i, []OpCode{PUSH1, CALLDATALOAD, PUSH1, SHR}, []int64{0, -1, 0xe0, -1}) {
+			shrSelector = true
+			earlySelector = earlySelector || i < 2
+		}
+		// CALLDATALOAD(0) / 0x2 ** 0xE0
+		if matchOps(insts, i, []OpCode{PUSH1, PUSH1, EXP, PUSH1, CALLDATALOAD, DIV}, []int64{0xe0, 2, -1, 0, -1, -1}) {
+			divSelector = true
+		}
+		// The check that no value was sent keeps a copy of CALLVALUE in
+		// legacy code, which is popped after the jump, but not in via-IR code
+		if matchOps(insts, i, []OpCode{CALLVALUE, DUP1, ISZERO, PUSH1, JUMPI}, nil) {
+			legacyCallValue = true
+		}
+		if matchOps(insts, i, []OpCode{CALLVALUE, ISZERO, PUSH1, JUMPI}, nil) {
+			irCallValue = true
+		}
+		// MSTORE(0x1C, CALLDATALOAD(0x0)), so the selector can be read with MLOAD(0)
+		if matchOps(insts, i, []OpCode{PUSH1, CALLDATALOAD, PUSH1, MSTORE}, []int64{0, -1, 0x1c, -1}) {
+			vyperSelector = true
+		}
+	}
+	// MSTORE(0x40, 0x60) or MSTORE(0x40, 0x80) at the entry point
+	var freeMemoryStart int64
+	for _, start := range []int64{0x60, 0x80} {
+		if matchOps(insts, 0, []OpCode{PUSH1, PUSH1, MSTORE}, []int64{start, 0x40, -1}) {
+			freeMemoryPointer, freeMemoryStart = true, start
+		}
+	}
+
+	switch {
+	case freeMemoryPointer:
+		fingerprint.Compiler = CompilerSolc
+		if freeMemoryStart == 0x60 {
+			fingerprint.narrow("", "0.4.22", "free memory pointer starts at 0x60")
+		} else {
+			fingerprint.narrow("0.4.22", "", "free memory pointer starts at 0x80")
+		}
+		if panics {
+			fingerprint.narrow("0.8.0", "", "Panic(uint256) errors")
+		}
+		if errorStrings {
+			fingerprint.narrow("0.4.22", "", "Error(string) revert reasons")
+		} else if revert {
+			fingerprint.narrow("0.4.10", "", "REVERT")
+		}
+		if push0 {
+			fingerprint.narrow("0.8.20", "", "PUSH0")
+		}
+		if mcopy {
+			fingerprint.narrow("0.8.25", "", "MCOPY")
+		}
+		if shrSelector {
+			fingerprint.narrow("0.5.0", "", "selector extracted with SHR")
+		} else if divSelector {
+			fingerprint.narrow("", "0.6.0", "selector extracted with DIV")
+		}
+		// Before 0.4.22, legacy code also checked CALLVALUE without a copy,
+		// so only trust that from versions with Panic(uint256)
+		if legacyCallValue {
+			fingerprint.Pipeline = PipelineLegacy
+			fingerprint.narrow("", "", "CALLVALUE kept across its check")
+		} else if irCallValue && panics {
+			fingerprint.Pipeline = PipelineIR
+			fingerprint.narrow("", "", "CALLVALUE checked without a copy")
+		}
+	case vyperSelector:
+		fingerprint.Compiler = CompilerVyper
+		fingerprint.narrow("", "", "selector stored to memory at 0x1C")
+	case earlySelector:
+		fingerprint.Compiler = CompilerHuff
+		fingerprint.narrow("", "", "selector extracted at entry point without free memory pointer")
+	case shrSelector:
+		fingerprint.Compiler = CompilerVyper
+		fingerprint.narrow("", "", "CALLDATALOAD(0x0) >> 0xE0 without free memory pointer")
+	}
+
+	return fingerprint
+}
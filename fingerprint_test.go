@@ -0,0 +1,65 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintProgram(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		code     string
+		compiler string
+		min, max string
+		pipeline string
+	}{
+		{"metadata", "6080604052600080fd" + "a26469706673582212200102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2064736f6c63430008130033", CompilerSolc, "0.8.19", "0.8.19", ""},
+		{"free memory at 0x60", "606060405200", CompilerSolc, "", "0.4.22", ""},
+		{"free memory at 0x80", "608060405200", CompilerSolc, "0.4.22", "", ""},
+		{"free memory elsewhere", "60a060405200", CompilerUnknown, "", "", ""},
+		// MSTORE(0x40, 0x80), and 'if (CALLVALUE()) { revert(0x0, 0x0) }' with
+		// a copy of CALLVALUE popped after the check
+		{"legacy", "6080604052348015600f57600080fd5b5000", CompilerSolc, "0.4.22", "", PipelineLegacy},
+		// The same check with no copy, PUSH0, and a Panic(uint256) selector
+		{"via-ir", "60806040523415600d575f80fd5b634e487b7100", CompilerSolc, "0.8.20", "", PipelineIR},
+		// Older solc checks without a copy too
+		{"no copy before 0.8", "60806040523415600d575f80fd5b00", CompilerSolc, "0.8.20", "", ""},
+		{"vyper", "600035601c5200", CompilerVyper, "", "", ""},
+		{"huff", "60003560e01c00", CompilerHuff, "", "", ""},
+	} {
+		bytecode, err := hex.DecodeString(test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFingerprint(t, test.name, FingerprintProgram(NewProgram(bytecode)), test.compiler, test.min, test.max, test.pipeline)
+	}
+
+	for _, test := range []struct {
+		path     string
+		compiler string
+		min, max string
+	}{
+		{"tests/attack1.bin", CompilerUnknown, "", ""},
+		{"tests/ballot.bin", CompilerSolc, "0.4.10", "0.4.22"},
+		{"tests/loop.bin", CompilerSolc, "", "0.4.22"},
+		{"tests/recursive.bin", CompilerSolc, "", "0.4.22"},
+	} {
+		data, err := os.ReadFile(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytecode, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFingerprint(t, test.path, FingerprintProgram(NewProgram(bytecode)), test.compiler, test.min, test.max, "")
+	}
+}
+
+func checkFingerprint(t *testing.T, name string, fingerprint *Fingerprint, compiler, min, max, pipeline string) {
+	if fingerprint.Compiler != compiler || fingerprint.MinVersion != min || fingerprint.MaxVersion != max || fingerprint.Pipeline != pipeline {
+		t.Errorf("%v: got %v, want %v >=%v <%v %v", name, fingerprint, compiler, min, max, pipeline)
+	}
+}
@@ -0,0 +1,103 @@
+package evmdis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fork identifies an Ethereum hard fork, and with it the set of opcodes that
+// were defined at the time.
+type Fork int
+
+const (
+	Frontier Fork = iota
+	Homestead
+	TangerineWhistle
+	SpuriousDragon
+	Byzantium
+	Constantinople
+	Petersburg
+	Istanbul
+	Berlin
+	London
+	Paris
+	Shanghai
+	Cancun
+	Prague
+
+	LatestFork = Prague
+)
+
+var forkToString = map[Fork]string{
+	Frontier:         "Frontier",
+	Homestead:        "Homestead",
+	TangerineWhistle: "TangerineWhistle",
+	SpuriousDragon:   "SpuriousDragon",
+	Byzantium:        "Byzantium",
+	Constantinople:   "Constantinople",
+	Petersburg:       "Petersburg",
+	Istanbul:         "Istanbul",
+	Berlin:           "Berlin",
+	London:           "London",
+	Paris:            "Paris",
+	Shanghai:         "Shanghai",
+	Cancun:           "Cancun",
+	Prague:           "Prague",
+}
+
+func (f Fork) String() string {
+	str := forkToString[f]
+	if len(str) == 0 {
+		return fmt.Sprintf("Fork(%d)", int(f))
+	}
+
+	return str
+}
+
+// ParseFork returns the fork with the given name, ignoring case.
+func ParseFork(name string) (Fork, error) {
+	for fork, str := range forkToString {
+		if strings.EqualFold(str, name) {
+			return fork, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fork %q", name)
+}
+
+// The fork each opcode was introduced in; opcodes not listed here have been
+// present since Frontier.
+var opCodeIntroducedIn = map[OpCode]Fork{
+	DELEGATECALL: Homestead,
+
+	REVERT:         Byzantium,
+	RETURNDATASIZE: Byzantium,
+	RETURNDATACOPY: Byzantium,
+	STATICCALL:     Byzantium,
+
+	SHL:         Constantinople,
+	SHR:         Constantinople,
+	SAR:         Constantinople,
+	EXTCODEHASH: Constantinople,
+	CREATE2:     Constantinople,
+
+	CHAINID:     Istanbul,
+	SELFBALANCE: Istanbul,
+
+	BASEFEE: London,
+
+	PUSH0: Shanghai,
+
+	TLOAD:       Cancun,
+	TSTORE:      Cancun,
+	MCOPY:       Cancun,
+	BLOBHASH:    Cancun,
+	BLOBBASEFEE: Cancun,
+}
+
+// IsAvailableIn returns true if op is a defined opcode in the given fork.
+func (op OpCode) IsAvailableIn(fork Fork) bool {
+	if _, ok := opCodeToString[op]; !ok || eofOnlyOpCodes[op] {
+		return false
+	}
+	return opCodeIntroducedIn[op] <= fork
+}
@@ -0,0 +1,334 @@
+package evmdis
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// InternalFunction is a function that is called by pushing a return address
+// and its arguments and jumping to its entry, and that returns by jumping to
+// the address it was passed, as Solidity's internal functions are.
+type InternalFunction struct {
+	Entry *BasicBlock
+	// Blocks executed by the function, not including those of the functions it
+	// calls, ordered by offset
+	Blocks []*BasicBlock
+	// Number of arguments and results, or -1 if they couldn't be determined
+	Arguments int
+	Results   int
+	Calls     []*InternalCall
+	// Jumps that return to the caller
+	Returns []InstructionPointer
+}
+
+// Name returns the name of the function, derived from the label of its entry.
+func (self *InternalFunction) Name() string {
+	var label *JumpLabel
+	self.Entry.Annotations.Get(&label)
+	if label != nil {
+		return "fn_" + strings.TrimPrefix(label.String(), ":")
+	}
+	return fmt.Sprintf("fn_0x%X", self.Entry.Offset)
+}
+
+func (self *InternalFunction) String() string {
+	names := func(prefix string, count int) string {
+		if count < 0 {
+			return "?"
+		}
+		var ret []string
+		for i := 0; i < count; i++ {
+			if prefix == "" && i < 26 {
+				ret = append(ret, string(rune('a'+i)))
+			} else if count == 1 {
+				ret = append(ret, prefix)
+			} else {
+				ret = append(ret, fmt.Sprintf("%v%d", prefix, i))
+			}
+		}
+		return strings.Join(ret, ", ")
+	}
+	ret := fmt.Sprintf("internal function %v(%v)", self.Name(), names("", self.Arguments))
+	if self.Results != 0 {
+		ret += fmt.Sprintf(" -> (%v)", names("r", self.Results))
+	}
+	return ret
+}
+
+// InternalCall is a call to an internal function. The instruction that makes
+// the call, a JUMP or the last instruction of a block that falls through to the
+// function's entry, is annotated with it.
+type InternalCall struct {
+	Function *InternalFunction
+	Site     InstructionPointer
+	// True if the call falls through to the function's entry rather than
+	// jumping to it
+	Fallthrough bool
+	// Block the function returns to
+	ReturnSite *BasicBlock
+	// Definitions of each argument, in the order they were pushed
+	Arguments []InstructionPointerSet
+}
+
+// CallExpression renders a call to an internal function.
+type CallExpression struct {
+	Call *InternalCall
+}
+
+func (self *InternalCall) String() string {
+	args := make([]string, 0, len(self.Arguments))
+	for _, arg := range self.Arguments {
+		args = append(args, arg.String())
+	}
+	return fmt.Sprintf("%v(%v)", self.Function.Name(), strings.Join(args, ", "))
+}
+
+func (self *CallExpression) String() string {
+	ret := self.Call.String()
+
+	var label *JumpLabel
+	self.Call.ReturnSite.Annotations.Get(&label)
+	if label != nil {
+		return fmt.Sprintf("%v -> %v", ret, label)
+	}
+	return fmt.Sprintf("%v -> 0x%X", ret, self.Call.ReturnSite.Offset)
+}
+
+func (self *CallExpression) Eval() *big.Int {
+	return nil
+}
+
+// stackAt returns the definitions on the stack before the instruction at index
+// in block is executed, or at the end of the block if index is the number of
+// instructions in it. The top of the stack is first.
+func stackAt(prog *Program, block *BasicBlock, index int) ReachingDefinition {
+	var prestate ReachingDefinition
+	block.Annotations.Get(&prestate)
+	if prestate == nil {
+		return nil
+	}
+	slots := append(ReachingDefinition{}, prestate...)
+	for i := 0; i < index; i++ {
+		inst := &block.Instructions[i]
+		reads := prog.StackReads(inst)
+		if len(slots) < reads {
+			return nil
+		}
+		ptr := InstructionPointerSet{InstructionPointer{block, i}: true}
+		switch {
+		case inst.Op.IsPush():
+			slots = append(ReachingDefinition{ptr}, slots...)
+		case inst.Op.IsDup():
+			slots = append(ReachingDefinition{slots[reads-1]}, slots...)
+		case inst.Op.IsSwap():
+			slots = append(ReachingDefinition{}, slots...)
+			slots[0], slots[reads-1] = slots[reads-1], slots[0]
+		case inst.Op == EXCHANGE:
+			a, b := exchangeDepths(inst)
+			slots = append(ReachingDefinition{}, slots...)
+			slots[a], slots[b] = slots[b], slots[a]
+		default:
+			slots = slots[reads:]
+			for j := 0; j < prog.StackWrites(inst); j++ {
+				slots = append(ReachingDefinition{ptr}, slots...)
+			}
+		}
+	}
+	return slots
+}
+
+// returnAddresses returns the blocks pushed by the instruction at ptr that are
+// consumed by a JUMP elsewhere, making it a candidate return address.
+func returnAddresses(prog *Program, ptr InstructionPointer) (*BasicBlock, []InstructionPointer) {
+	inst := ptr.Get()
+	if !inst.Op.IsPush() || !inst.Arg.IsInt64() {
+		return nil, nil
+	}
+	target := prog.JumpDestinations[int(inst.Arg.Int64())]
+	if target == nil {
+		return nil, nil
+	}
+	var reaches ReachesDefinition
+	inst.Annotations.Get(&reaches)
+	var jumps []InstructionPointer
+	for _, consumer := range reaches {
+		if consumer.Get().Op != JUMP {
+			// Return addresses are only ever jumped to
+			return nil, nil
+		}
+		if consumer.OriginBlock != ptr.OriginBlock {
+			jumps = append(jumps, consumer)
+		}
+	}
+	if len(jumps) == 0 {
+		return nil, nil
+	}
+	return target, jumps
+}
+
+// PerformInternalFunctionAnalysis finds internal functions from the pattern of
+// their calls: a block pushes a return address and ends by jumping, or falling
+// through, to the function's entry, and the return address is later consumed
+// by a JUMP in the function. The entry block of each function is annotated
+// with its InternalFunction, and each call site with its InternalCall. Reaches
+// analysis must already have been performed.
+func PerformInternalFunctionAnalysis(prog *Program) {
+	functions := make(map[*BasicBlock]*InternalFunction)
+	// Return jumps, and the functions they return from
+	returns := make(map[InstructionPointer]*InternalFunction)
+	callBlocks := make(map[*BasicBlock]*InternalCall)
+
+	// Calls that fall through to a function are only recognised once it has
+	// been found from calls that jump to it, so look for those first
+	for _, falls := range []bool{false, true} {
+		for _, block := range prog.Blocks {
+			var prestate ReachingDefinition
+			block.Annotations.Get(&prestate)
+			if prestate == nil || len(block.Instructions) == 0 {
+				continue
+			}
+
+			// The call is made at the end of the block, with the most recently
+			// pushed return address
+			last := len(block.Instructions) - 1
+			var entry *BasicBlock
+			var site InstructionPointer
+			var stack ReachingDefinition
+			switch block.Instructions[last].Op {
+			case JUMP:
+				if falls || len(block.Successors) != 1 || block.Successors[0].Kind != EdgeJump {
+					continue
+				}
+				entry = block.Successors[0].To
+				site = InstructionPointer{block, last}
+				if stack = stackAt(prog, block, last); len(stack) > 0 {
+					stack = stack[1:]
+				}
+			default:
+				if !falls || len(block.Successors) != 1 || block.Successors[0].Kind != EdgeFallthrough {
+					continue
+				}
+				entry = block.Successors[0].To
+				if functions[entry] == nil {
+					continue
+				}
+				site = InstructionPointer{block, last}
+				stack = stackAt(prog, block, len(block.Instructions))
+			}
+
+			var returnSite *BasicBlock
+			var returnJumps []InstructionPointer
+			var returnAddress InstructionPointer
+			for i := len(block.Instructions) - 1; i >= 0 && returnSite == nil; i-- {
+				returnAddress = InstructionPointer{block, i}
+				returnSite, returnJumps = returnAddresses(prog, returnAddress)
+			}
+			if returnSite == nil {
+				continue
+			}
+			position := -1
+			for i, slot := range stack {
+				if slot[returnAddress] {
+					position = i
+					break
+				}
+			}
+			if position < 0 {
+				continue
+			}
+
+			function := functions[entry]
+			if function == nil {
+				function = &InternalFunction{Entry: entry, Arguments: position, Results: -1}
+				functions[entry] = function
+			} else if function.Arguments != position {
+				function.Arguments = -1
+			}
+
+			call := &InternalCall{
+				Function:    function,
+				Site:        site,
+				Fallthrough: falls,
+				ReturnSite:  returnSite,
+			}
+			for i := position - 1; i >= 0; i-- {
+				call.Arguments = append(call.Arguments, stack[i])
+			}
+			function.Calls = append(function.Calls, call)
+			callBlocks[block] = call
+
+			// Results are whatever the return site finds on the stack above the
+			// caller's own items
+			var returned ReachingDefinition
+			returnSite.Annotations.Get(&returned)
+			results := len(returned) - (len(stack) - position - 1)
+			if returned == nil || results < 0 {
+				results = -1
+			}
+			// Some functions return with DUP and JUMP, leaving their return
+			// address on the stack below their results for the caller to pop
+			for results > 0 && len(returned[results-1]) == 1 && returned[results-1][returnAddress] {
+				results--
+			}
+			if len(function.Calls) == 1 {
+				function.Results = results
+			} else if function.Results != results {
+				function.Results = -1
+			}
+
+			for _, jump := range returnJumps {
+				if returns[jump] == nil {
+					returns[jump] = function
+					function.Returns = append(function.Returns, jump)
+				}
+			}
+		}
+	}
+
+	for _, block := range prog.Blocks {
+		function := functions[block]
+		if function == nil {
+			continue
+		}
+
+		// The body is everything reachable from the entry, stepping over
+		// calls to other functions, up to the function's returns
+		body := map[*BasicBlock]bool{block: true}
+		pending := []*BasicBlock{block}
+		for len(pending) > 0 {
+			var current *BasicBlock
+			current, pending = pending[len(pending)-1], pending[:len(pending)-1]
+			var next []*BasicBlock
+			if call := callBlocks[current]; call != nil {
+				next = []*BasicBlock{call.ReturnSite}
+			} else if last := len(current.Instructions) - 1; last < 0 || returns[InstructionPointer{current, last}] == nil {
+				next = successorBlocks(current)
+			}
+			for _, successor := range next {
+				if !body[successor] {
+					body[successor] = true
+					pending = append(pending, successor)
+				}
+			}
+		}
+		for _, member := range prog.Blocks {
+			if body[member] {
+				function.Blocks = append(function.Blocks, member)
+			}
+		}
+
+		sort.Slice(function.Calls, func(i, j int) bool {
+			return function.Calls[i].Site.GetAddress() < function.Calls[j].Site.GetAddress()
+		})
+		sort.Slice(function.Returns, func(i, j int) bool {
+			return function.Returns[i].GetAddress() < function.Returns[j].GetAddress()
+		})
+		block.Annotations.Set(&function)
+		for _, call := range function.Calls {
+			call.Site.Get().Annotations.Set(&call)
+		}
+		prog.InternalFunctions = append(prog.InternalFunctions, function)
+	}
+}
@@ -0,0 +1,52 @@
+package evmdis
+
+import (
+	"testing"
+)
+
+func TestInternalFunctions(t *testing.T) {
+	prog := analyzeHex(t, sharedHelperCode, DefaultOptions())
+	if len(prog.InternalFunctions) != 1 {
+		t.Fatalf("got %d internal functions, want 1", len(prog.InternalFunctions))
+	}
+	function := prog.InternalFunctions[0]
+	if function.Entry != prog.JumpDestinations[0x12] || function.Arguments != 1 || function.Results != 1 {
+		t.Errorf("got %v entered at 0x%X", function, function.Entry.Offset)
+	}
+	if got := function.String(); got != "internal function fn_label2(a) -> (r)" {
+		t.Errorf("got %q", got)
+	}
+	if len(function.Returns) != 1 || function.Returns[0].Get().Op != JUMP {
+		t.Errorf("got returns %v, want the JUMP at 0x17", function.Returns)
+	}
+
+	if len(function.Calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(function.Calls))
+	}
+	for i, want := range []struct {
+		site, returnSite int
+		argument         OpCode
+	}{
+		{0x6, 0x7, PUSH1},
+		{0xF, 0x10, PUSH1},
+	} {
+		call := function.Calls[i]
+		if call.Site.GetAddress() != want.site || call.ReturnSite != prog.JumpDestinations[want.returnSite] || call.Fallthrough {
+			t.Errorf("call %d: got a call at 0x%X returning to 0x%X", i, call.Site.GetAddress(), call.ReturnSite.Offset)
+		}
+		if len(call.Arguments) != 1 || len(call.Arguments[0]) != 1 || call.Arguments[0].First().Get().Op != want.argument {
+			t.Errorf("call %d: got arguments %v", i, call.Arguments)
+		}
+		var annotation *InternalCall
+		call.Site.Get().Annotations.Get(&annotation)
+		if annotation != call {
+			t.Errorf("call %d: the call site isn't annotated with it", i)
+		}
+	}
+
+	// Jumps to a shared block that don't pass a return address aren't calls
+	prog = analyzeHex(t, "346007576001505b00", DefaultOptions())
+	if len(prog.InternalFunctions) != 0 {
+		t.Errorf("got internal functions %v, want none", prog.InternalFunctions)
+	}
+}
@@ -0,0 +1,598 @@
+package evmdis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Arachnid/evmdis/metadata"
+)
+
+// JSONSchemaVersion is the version of the JSON output format. It is incremented
+// whenever a change is made that existing consumers might not cope with, such as
+// removing or changing the meaning of a field.
+const JSONSchemaVersion = 2
+
+// Definitions of stack values are given as the offset of the instruction that
+// defined them. The inputs of an EOF code section are given as -1 - index, so
+// input0 is -1, input1 is -2 and so on.
+
+// JSONDocument is the top level of the JSON output for a piece of bytecode,
+// which may contain several programs, such as a constructor and the code it
+// deploys or the code sections of an EOF container.
+type JSONDocument struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Metadata      *JSONMetadata  `json:"metadata,omitempty"`
+	Compiler      *JSONCompiler  `json:"compiler,omitempty"`
+	Programs      []*JSONProgram `json:"programs"`
+}
+
+// JSONCompiler is the compiler fingerprint. Min is the lowest version the code
+// may have been compiled with, and Max the first it can't have been; either is
+// omitted if unbounded.
+type JSONCompiler struct {
+	Name     string   `json:"name"`
+	Min      string   `json:"min,omitempty"`
+	Max      string   `json:"max,omitempty"`
+	Pipeline string   `json:"pipeline,omitempty"`
+	Evidence []string `json:"evidence"`
+}
+
+type JSONMetadata struct {
+	Compiler        string `json:"compiler,omitempty"`
+	CompilerVersion string `json:"compilerVersion,omitempty"`
+	IPFS            string `json:"ipfs,omitempty"`
+	Swarm           string `json:"swarm,omitempty"`
+	Experimental    bool   `json:"experimental,omitempty"`
+}
+
+type JSONProgram struct {
+	// Describes which part of the bytecode this is, eg "code", "constructor"
+	// or "section 0"
+	Name        string            `json:"name"`
+	Fork        string            `json:"fork"`
+	Blocks      []*JSONBlock      `json:"blocks"`
+	Labels      []*JSONLabel      `json:"labels"`
+	DataRegions []*JSONDataRegion `json:"dataRegions"`
+	Diagnostics []*JSONDiagnostic `json:"diagnostics"`
+	Dispatcher  *JSONDispatcher   `json:"dispatcher,omitempty"`
+	// Internal functions, ordered by entry offset
+	InternalFunctions []*JSONInternalFunction `json:"internalFunctions"`
+}
+
+// JSONInternalFunction describes an internal function. Arguments and Results
+// are -1 if they couldn't be determined.
+type JSONInternalFunction struct {
+	Name      string              `json:"name"`
+	Entry     int                 `json:"entry"`
+	Blocks    []int               `json:"blocks"`
+	Arguments int                 `json:"arguments"`
+	Results   int                 `json:"results"`
+	Calls     []*JSONInternalCall `json:"calls"`
+	// Offsets of the jumps that return to the caller
+	Returns []int `json:"returns"`
+}
+
+type JSONInternalCall struct {
+	// Offset of the jump, or of the last instruction of a block that falls
+	// through to the function
+	Site        int  `json:"site"`
+	Fallthrough bool `json:"fallthrough"`
+	ReturnSite  int  `json:"returnSite"`
+	// Definitions of each argument, in the order they were pushed
+	Arguments [][]int `json:"arguments"`
+}
+
+// JSONDispatcher describes the function dispatcher of a program, if one was
+// recognised.
+type JSONDispatcher struct {
+	Style     string                  `json:"style"`
+	Blocks    []int                   `json:"blocks"`
+	Fallback  *int                    `json:"fallback"`
+	Functions []*JSONExternalFunction `json:"functions"`
+}
+
+type JSONExternalFunction struct {
+	// Hex encoded four byte selector
+	Selector string `json:"selector"`
+	Entry    int    `json:"entry"`
+	Blocks   []int  `json:"blocks"`
+	// Signatures the selector may have been derived from, if known
+	Signatures []string `json:"signatures,omitempty"`
+}
+
+type JSONBlock struct {
+	Offset int    `json:"offset"`
+	End    int    `json:"end"`
+	Label  string `json:"label,omitempty"`
+	// False if the reaching analysis never reached the block, in which case
+	// Stack is null
+	Reachable bool `json:"reachable"`
+	// Definitions of the stack slots at the start of the block, from the top
+	Stack      [][]int     `json:"stack"`
+	Successors []*JSONEdge `json:"successors"`
+	// Offsets of the block's immediate dominator and post-dominator, if any
+	ImmediateDominator     *int      `json:"immediateDominator,omitempty"`
+	ImmediatePostDominator *int      `json:"immediatePostDominator,omitempty"`
+	Loop                   *JSONLoop `json:"loop,omitempty"`
+	// Stack at the start of the block in each calling context, if the
+	// reaching analysis distinguished contexts
+	Contexts []*JSONContext `json:"contexts,omitempty"`
+	// Definitions on the stack at the start of the block whose values are
+	// used later, if liveness analysis was performed
+	Live         []int              `json:"live,omitempty"`
+	Instructions []*JSONInstruction `json:"instructions"`
+}
+
+// JSONContext holds definitions that reach a block or instruction in one
+// calling context, identified by the offsets of the instructions that pushed
+// its return addresses, innermost first.
+type JSONContext struct {
+	Context     []int   `json:"context"`
+	Definitions [][]int `json:"definitions"`
+}
+
+// JSONLoop describes the loop a block is the header of.
+type JSONLoop struct {
+	// Offsets of the blocks in the loop, and of those with back edges to the
+	// header
+	Body      []int `json:"body"`
+	BackEdges []int `json:"backEdges"`
+	// Edges leaving the loop
+	Exits []*JSONLoopExit `json:"exits"`
+}
+
+type JSONLoopExit struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type JSONEdge struct {
+	To   int    `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type JSONInstruction struct {
+	Offset int    `json:"offset"`
+	Op     string `json:"op"`
+	// Hex encoded argument of a push, or raw byte of an undefined opcode
+	Arg string `json:"arg,omitempty"`
+	// Expression the instruction was lifted into, if it wasn't incorporated
+	// into the expression of a later instruction
+	Expression *JSONExpression `json:"expression,omitempty"`
+	// Definitions reaching each argument, and the instructions that consume
+	// the instruction's result; null if the instruction wasn't reached
+	Reaching [][]int `json:"reaching"`
+	Reaches  []int   `json:"reaches"`
+	// Definitions reaching each argument in each calling context, if the
+	// reaching analysis distinguished contexts
+	Contexts []*JSONContext `json:"contexts,omitempty"`
+	// Signatures of the function selector compared against, or event logged
+	Signatures []string `json:"signatures,omitempty"`
+	// Values the instruction may produce, unless they could be anything
+	Value *JSONValue `json:"value,omitempty"`
+	// "always" or "never" for a conditional jump whose condition is constant
+	Branch string `json:"branch,omitempty"`
+}
+
+// JSONValue is the set of values an instruction may produce: the hex encoded
+// values, if there are few enough of them to enumerate, and the interval they
+// lie in.
+type JSONValue struct {
+	Values []string `json:"values,omitempty"`
+	Min    string   `json:"min"`
+	Max    string   `json:"max"`
+}
+
+// JSONExpression is a node of an expression tree. Kind is one of
+// "instruction", "label", "section", "immediate", "input", "pop", "swap",
+// "exchange", "dup", "call" or "constant"; Text is the expression as it's rendered in the
+// text output. The argument of a "constant" is the expression it was folded
+// from.
+type JSONExpression struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	// Opcode and offset of an "instruction" node, or definition popped by a
+	// "pop" node
+	Op     string `json:"op,omitempty"`
+	Offset *int   `json:"offset,omitempty"`
+	// Hex encoded value of a constant
+	Value string `json:"value,omitempty"`
+	// Stack depth of a "swap" or "dup", or index of a "section" or "input"
+	Index *int `json:"index,omitempty"`
+	// Stack depths of the items an "exchange" swaps
+	Depths []int `json:"depths,omitempty"`
+	// Definitions a "pop" node takes in each calling context, if there's
+	// one in each
+	Definitions []int             `json:"definitions,omitempty"`
+	Arguments   []*JSONExpression `json:"arguments,omitempty"`
+}
+
+type JSONLabel struct {
+	Name       string `json:"name"`
+	Offset     int    `json:"offset"`
+	References int    `json:"references"`
+}
+
+type JSONDataRegion struct {
+	Offset int    `json:"offset"`
+	Data   string `json:"data"`
+	Reason string `json:"reason"`
+}
+
+type JSONDiagnostic struct {
+	Severity string `json:"severity"`
+	Analysis string `json:"analysis"`
+	// Offset of the block and instruction the diagnostic applies to, or null
+	// if it applies to the whole program
+	Block   *int   `json:"block"`
+	Offset  *int   `json:"offset"`
+	Message string `json:"message"`
+}
+
+func (self *JSONDocument) String() string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(self); err != nil {
+		panic(fmt.Sprintf("Could not encode JSON: %v", err))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// NewJSONDocument creates an empty JSON document, describing the given
+// metadata and fingerprint if they're non-nil.
+func NewJSONDocument(meta *metadata.Metadata, fingerprint *Fingerprint) *JSONDocument {
+	document := &JSONDocument{
+		SchemaVersion: JSONSchemaVersion,
+		Programs:      []*JSONProgram{},
+	}
+	if meta != nil {
+		document.Metadata = &JSONMetadata{
+			Compiler:        meta.Compiler,
+			CompilerVersion: meta.CompilerVersion,
+			IPFS:            meta.IPFSHash(),
+			Experimental:    meta.Experimental,
+		}
+		if meta.Swarm != nil {
+			document.Metadata.Swarm = fmt.Sprintf("0x%x", meta.Swarm)
+		}
+	}
+	if fingerprint != nil && fingerprint.Compiler != CompilerUnknown {
+		document.Compiler = &JSONCompiler{
+			Name:     fingerprint.Compiler,
+			Min:      fingerprint.MinVersion,
+			Max:      fingerprint.MaxVersion,
+			Pipeline: fingerprint.Pipeline,
+			Evidence: fingerprint.Evidence,
+		}
+	}
+	return document
+}
+
+func jsonHex(value *big.Int) string {
+	return fmt.Sprintf("0x%X", value)
+}
+
+func jsonInt(value int) *int {
+	return &value
+}
+
+// jsonDefinition returns the JSON representation of the definition at ptr.
+func jsonDefinition(ptr InstructionPointer) int {
+	if ptr.OriginBlock.Offset < 0 {
+		return -1 - ptr.OriginIndex
+	}
+	return ptr.GetAddress()
+}
+
+func jsonContexts(contexts ContextReachingDefinition) []*JSONContext {
+	var ret []*JSONContext
+	for _, context := range contexts {
+		jsonContext := &JSONContext{Context: []int{}, Definitions: jsonDefinitions(context.Reaching)}
+		for _, ptr := range context.Context {
+			jsonContext.Context = append(jsonContext.Context, jsonDefinition(ptr))
+		}
+		ret = append(ret, jsonContext)
+	}
+	return ret
+}
+
+func jsonDefinitions(reaching ReachingDefinition) [][]int {
+	if reaching == nil {
+		return nil
+	}
+	ret := make([][]int, len(reaching))
+	for i, pointers := range reaching {
+		ret[i] = []int{}
+		for _, pointer := range pointers.Sorted() {
+			ret[i] = append(ret[i], jsonDefinition(pointer))
+		}
+	}
+	return ret
+}
+
+// jsonOp returns the name of an instruction's opcode.
+func jsonOp(inst *Instruction) string {
+	if inst.Undefined {
+		return "UNDEFINED"
+	}
+	return inst.Op.String()
+}
+
+// newJSONExpression converts an expression tree into its JSON representation,
+// given the offsets of the program's instructions.
+func newJSONExpression(offsets map[*Instruction]int, expression Expression) *JSONExpression {
+	ret := &JSONExpression{Text: expression.String()}
+	switch expression := expression.(type) {
+	case *InstructionExpression:
+		ret.Kind = "instruction"
+		ret.Op = jsonOp(expression.Inst)
+		if offset, ok := offsets[expression.Inst]; ok {
+			ret.Offset = jsonInt(offset)
+		}
+		if expression.Inst.Op.IsPush() {
+			ret.Value = jsonHex(expression.Inst.Arg)
+		}
+		for _, arg := range expression.Arguments {
+			ret.Arguments = append(ret.Arguments, newJSONExpression(offsets, arg))
+		}
+	case *JumpLabel:
+		ret.Kind = "label"
+	case *SectionLabel:
+		ret.Kind = "section"
+		ret.Index = jsonInt(expression.Index)
+	case *ImmediateExpression:
+		ret.Kind = "immediate"
+		ret.Value = jsonHex(expression.Value)
+	case *InputExpression:
+		ret.Kind = "input"
+		ret.Index = jsonInt(expression.Index)
+	case *PopExpression:
+		ret.Kind = "pop"
+		if expression.Inst != nil {
+			ret.Offset = jsonInt(jsonDefinition(*expression.Inst))
+		}
+		for _, ptr := range expression.Contexts {
+			ret.Definitions = append(ret.Definitions, jsonDefinition(ptr))
+		}
+	case *SwapExpression:
+		ret.Kind = "swap"
+		ret.Index = jsonInt(expression.count)
+	case *ExchangeExpression:
+		ret.Kind = "exchange"
+		ret.Depths = []int{expression.first, expression.second}
+	case *DupExpression:
+		ret.Kind = "dup"
+		ret.Index = jsonInt(expression.count)
+	case *ConstantExpression:
+		ret.Kind = "constant"
+		ret.Value = jsonHex(expression.Value)
+		ret.Arguments = []*JSONExpression{newJSONExpression(offsets, expression.Folded)}
+	case *CallExpression:
+		ret.Kind = "call"
+		ret.Op = expression.Call.Site.Get().Op.String()
+		ret.Offset = jsonInt(expression.Call.Site.GetAddress())
+	}
+	return ret
+}
+
+// NewJSONProgram converts an analyzed program into its JSON representation.
+func NewJSONProgram(name string, prog *Program) *JSONProgram {
+	ret := &JSONProgram{
+		Name:              name,
+		Fork:              prog.Fork.String(),
+		Blocks:            []*JSONBlock{},
+		Labels:            []*JSONLabel{},
+		DataRegions:       []*JSONDataRegion{},
+		Diagnostics:       []*JSONDiagnostic{},
+		InternalFunctions: []*JSONInternalFunction{},
+	}
+
+	for _, function := range prog.InternalFunctions {
+		jsonFunction := &JSONInternalFunction{
+			Name:      function.Name(),
+			Entry:     function.Entry.Offset,
+			Blocks:    []int{},
+			Arguments: function.Arguments,
+			Results:   function.Results,
+			Calls:     []*JSONInternalCall{},
+			Returns:   []int{},
+		}
+		for _, block := range function.Blocks {
+			jsonFunction.Blocks = append(jsonFunction.Blocks, block.Offset)
+		}
+		for _, call := range function.Calls {
+			jsonFunction.Calls = append(jsonFunction.Calls, &JSONInternalCall{
+				Site:        call.Site.GetAddress(),
+				Fallthrough: call.Fallthrough,
+				ReturnSite:  call.ReturnSite.Offset,
+				Arguments:   append([][]int{}, jsonDefinitions(call.Arguments)...),
+			})
+		}
+		for _, jump := range function.Returns {
+			jsonFunction.Returns = append(jsonFunction.Returns, jump.GetAddress())
+		}
+		ret.InternalFunctions = append(ret.InternalFunctions, jsonFunction)
+	}
+
+	if dispatcher := prog.Dispatcher; dispatcher != nil {
+		ret.Dispatcher = &JSONDispatcher{
+			Style:     dispatcher.Style,
+			Blocks:    []int{},
+			Functions: []*JSONExternalFunction{},
+		}
+		for _, block := range dispatcher.Blocks {
+			ret.Dispatcher.Blocks = append(ret.Dispatcher.Blocks, block.Offset)
+		}
+		if dispatcher.Fallback != nil {
+			ret.Dispatcher.Fallback = jsonInt(dispatcher.Fallback.Offset)
+		}
+		for _, function := range dispatcher.Functions {
+			jsonFunction := &JSONExternalFunction{
+				Selector:   fmt.Sprintf("0x%08x", function.Selector),
+				Entry:      function.Entry.Offset,
+				Blocks:     []int{},
+				Signatures: function.Signatures,
+			}
+			for _, block := range function.Blocks {
+				jsonFunction.Blocks = append(jsonFunction.Blocks, block.Offset)
+			}
+			ret.Dispatcher.Functions = append(ret.Dispatcher.Functions, jsonFunction)
+		}
+	}
+
+	offsets := make(map[*Instruction]int)
+	for _, block := range prog.Blocks {
+		offset := block.Offset
+		for i := range block.Instructions {
+			offsets[&block.Instructions[i]] = offset
+			offset += block.Instructions[i].Size()
+		}
+	}
+
+	for _, block := range prog.Blocks {
+		var reaching ReachingDefinition
+		block.Annotations.Get(&reaching)
+		jsonBlock := &JSONBlock{
+			Offset:       block.Offset,
+			End:          block.End(),
+			Reachable:    reaching != nil,
+			Stack:        jsonDefinitions(reaching),
+			Successors:   []*JSONEdge{},
+			Instructions: []*JSONInstruction{},
+		}
+		var contexts ContextReachingDefinition
+		block.Annotations.Get(&contexts)
+		jsonBlock.Contexts = jsonContexts(contexts)
+		var live LiveDefinitions
+		block.Annotations.Get(&live)
+		for _, pointer := range InstructionPointerSet(live).Sorted() {
+			jsonBlock.Live = append(jsonBlock.Live, jsonDefinition(pointer))
+		}
+
+		var label *JumpLabel
+		block.Annotations.Get(&label)
+		if label != nil {
+			jsonBlock.Label = label.String()
+			ret.Labels = append(ret.Labels, &JSONLabel{label.String(), block.Offset, label.refCount})
+		}
+
+		for _, edge := range block.Successors {
+			jsonBlock.Successors = append(jsonBlock.Successors, &JSONEdge{edge.To.Offset, edge.Kind.String()})
+		}
+
+		var dominators *Dominators
+		block.Annotations.Get(&dominators)
+		if dominators != nil && dominators.Immediate != nil {
+			jsonBlock.ImmediateDominator = jsonInt(dominators.Immediate.Offset)
+		}
+		var postDominators *PostDominators
+		block.Annotations.Get(&postDominators)
+		if postDominators != nil && postDominators.Immediate != nil {
+			jsonBlock.ImmediatePostDominator = jsonInt(postDominators.Immediate.Offset)
+		}
+
+		var loop *Loop
+		block.Annotations.Get(&loop)
+		if loop != nil {
+			jsonBlock.Loop = &JSONLoop{
+				Body:      []int{},
+				BackEdges: []int{},
+				Exits:     []*JSONLoopExit{},
+			}
+			for _, member := range loop.Body {
+				jsonBlock.Loop.Body = append(jsonBlock.Loop.Body, member.Offset)
+			}
+			for _, edge := range loop.BackEdges {
+				jsonBlock.Loop.BackEdges = append(jsonBlock.Loop.BackEdges, edge.From.Offset)
+			}
+			for _, edge := range loop.Exits {
+				jsonBlock.Loop.Exits = append(jsonBlock.Loop.Exits, &JSONLoopExit{edge.From.Offset, edge.To.Offset, edge.Kind.String()})
+			}
+		}
+
+		offset := block.Offset
+		for i := range block.Instructions {
+			inst := &block.Instructions[i]
+			jsonInst := &JSONInstruction{
+				Offset: offset,
+				Op:     jsonOp(inst),
+			}
+			if inst.Arg != nil {
+				jsonInst.Arg = jsonHex(inst.Arg)
+			}
+
+			var expression Expression
+			inst.Annotations.Get(&expression)
+			if expression != nil {
+				jsonInst.Expression = newJSONExpression(offsets, expression)
+			}
+			var signatures Signatures
+			inst.Annotations.Get(&signatures)
+			jsonInst.Signatures = signatures
+
+			var value *ValueSet
+			inst.Annotations.Get(&value)
+			if value != nil && !value.IsTop() {
+				jsonInst.Value = &JSONValue{Min: jsonHex(value.Min), Max: jsonHex(value.Max)}
+				for _, member := range value.Values {
+					jsonInst.Value.Values = append(jsonInst.Value.Values, jsonHex(member))
+				}
+			}
+			var branch Branch
+			inst.Annotations.Get(&branch)
+			switch branch {
+			case BranchAlways:
+				jsonInst.Branch = "always"
+			case BranchNever:
+				jsonInst.Branch = "never"
+			}
+
+			var reaching ReachingDefinition
+			inst.Annotations.Get(&reaching)
+			jsonInst.Reaching = jsonDefinitions(reaching)
+
+			var reaches ReachesDefinition
+			inst.Annotations.Get(&reaches)
+			if reaching != nil {
+				jsonInst.Reaches = []int{}
+			}
+			for _, pointer := range reaches {
+				jsonInst.Reaches = append(jsonInst.Reaches, jsonDefinition(pointer))
+			}
+
+			var contexts ContextReachingDefinition
+			inst.Annotations.Get(&contexts)
+			jsonInst.Contexts = jsonContexts(contexts)
+
+			jsonBlock.Instructions = append(jsonBlock.Instructions, jsonInst)
+			offset += inst.Size()
+		}
+
+		ret.Blocks = append(ret.Blocks, jsonBlock)
+	}
+
+	for _, region := range prog.DataRegions {
+		ret.DataRegions = append(ret.DataRegions, &JSONDataRegion{region.Offset, fmt.Sprintf("0x%x", region.Data), region.Reason})
+	}
+
+	for _, diagnostic := range prog.Diagnostics.List {
+		jsonDiagnostic := &JSONDiagnostic{
+			Severity: diagnostic.Severity.String(),
+			Analysis: diagnostic.Analysis,
+			Message:  diagnostic.Message,
+		}
+		if diagnostic.Block != nil {
+			jsonDiagnostic.Block = jsonInt(diagnostic.Block.Offset)
+			jsonDiagnostic.Offset = jsonInt(diagnostic.Offset)
+		}
+		ret.Diagnostics = append(ret.Diagnostics, jsonDiagnostic)
+	}
+
+	return ret
+}
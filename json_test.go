@@ -0,0 +1,70 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("tests/loop.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode, _ := hex.DecodeString(strings.TrimSpace(string(data)))
+	log.SetOutput(io.Discard)
+	options := DefaultOptions()
+	options.Format = "json"
+	result, err := Analyze(bytecode, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document JSONDocument
+	if err := json.Unmarshal([]byte(result.Output), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.String() != result.Output {
+		t.Errorf("output changed after decoding and encoding it again")
+	}
+
+	if document.SchemaVersion != JSONSchemaVersion {
+		t.Errorf("got schema version %d", document.SchemaVersion)
+	}
+	compiler := document.Compiler
+	if compiler == nil || compiler.Name != CompilerSolc || compiler.Min != "" || compiler.Max != "0.4.22" || len(compiler.Evidence) != 2 {
+		t.Errorf("got compiler %+v", compiler)
+	}
+	if len(document.Programs) != 1 {
+		t.Fatalf("got %d programs, want 1", len(document.Programs))
+	}
+	program := document.Programs[0]
+	if program.Name != "code" || program.Fork != LatestFork.String() || program.Dispatcher == nil || len(program.Dispatcher.Functions) != 1 {
+		t.Errorf("got program %v, fork %v and dispatcher %+v", program.Name, program.Fork, program.Dispatcher)
+	}
+
+	// Each successor is one of the blocks, and the loop's header has the
+	// back edge
+	blocks := make(map[int]*JSONBlock)
+	for _, block := range program.Blocks {
+		blocks[block.Offset] = block
+	}
+	loops := 0
+	for _, block := range program.Blocks {
+		for _, edge := range block.Successors {
+			if blocks[edge.To] == nil {
+				t.Errorf("edge from 0x%X to 0x%X, which isn't a block", block.Offset, edge.To)
+			}
+		}
+		if block.Loop != nil {
+			loops++
+		}
+	}
+	if loops != 1 {
+		t.Errorf("got %d loop headers, want 1", loops)
+	}
+}
@@ -0,0 +1,95 @@
+package evmdis
+
+// LiveDefinitions annotates each block reached by the reaching analysis with
+// the definitions on the stack at its start whose values are still to be used.
+type LiveDefinitions InstructionPointerSet
+
+// Liveness is a backward dataflow analysis of the definitions whose values will
+// be used later: read by an instruction other than a POP, which discards its
+// operand, or a DUP, SWAP or EXCHANGE, which only move values around the stack.
+type Liveness struct {
+	Live InstructionPointerSet
+}
+
+func (self *Liveness) Process(ptr InstructionPointer) Analysis {
+	inst := ptr.Get()
+	// Nothing defined here is live before the definition
+	delete(self.Live, ptr)
+	if inst.Op != POP && !inst.Op.IsDup() && !inst.Op.IsSwap() && inst.Op != EXCHANGE {
+		var reaching ReachingDefinition
+		inst.Annotations.Get(&reaching)
+		for _, pointers := range reaching {
+			for pointer := range pointers {
+				self.Live[pointer] = true
+			}
+		}
+	}
+	if ptr.OriginIndex == 0 {
+		self.enter(ptr.OriginBlock)
+	}
+	return self
+}
+
+// enter keeps only the definitions that can be on the stack at the start of
+// block. A slot that merges definitions from several predecessors doesn't make
+// them all live in each of them.
+func (self *Liveness) enter(block *BasicBlock) {
+	var prestate ReachingDefinition
+	block.Annotations.Get(&prestate)
+	onStack := make(InstructionPointerSet)
+	for _, pointers := range prestate {
+		for pointer := range pointers {
+			onStack[pointer] = true
+		}
+	}
+	for pointer := range self.Live {
+		if !onStack[pointer] {
+			delete(self.Live, pointer)
+		}
+	}
+}
+
+func (self *Liveness) Combine(other Analysis) Analysis {
+	for pointer := range other.(*Liveness).Live {
+		self.Live[pointer] = true
+	}
+	return self
+}
+
+func (self *Liveness) Copy() Analysis {
+	live := make(InstructionPointerSet, len(self.Live))
+	for pointer := range self.Live {
+		live[pointer] = true
+	}
+	return &Liveness{live}
+}
+
+func (self *Liveness) Equals(other Analysis) bool {
+	live := other.(*Liveness).Live
+	if len(live) != len(self.Live) {
+		return false
+	}
+	for pointer := range self.Live {
+		if !live[pointer] {
+			return false
+		}
+	}
+	return true
+}
+
+// PerformLivenessAnalysis annotates each reachable block with its
+// LiveDefinitions, and returns the result of the Liveness analysis for finding
+// those at any instruction. Reaching analysis must already have been performed.
+func PerformLivenessAnalysis(prog *Program) *DataflowResult {
+	result := PerformDataflowAnalysis(prog, &Liveness{make(InstructionPointerSet)}, DataflowOptions{Direction: Backward})
+	for _, block := range prog.Blocks {
+		var reaching ReachingDefinition
+		block.Annotations.Get(&reaching)
+		if reaching == nil || result.In[block] == nil {
+			continue
+		}
+		live := LiveDefinitions(result.In[block].(*Liveness).Live)
+		block.Annotations.Set(&live)
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package evmdis
+
+// Loop is a natural loop: a header block that dominates every block in the
+// loop, and the back edges that jump from the body to the header. Loops that
+// share a header are merged.
+type Loop struct {
+	Header *BasicBlock
+	// Blocks in the loop, including the header, ordered by offset
+	Body []*BasicBlock
+	// Edges from the body back to the header
+	BackEdges []*Edge
+	// Edges from the body to blocks outside the loop
+	Exits []*Edge
+	// Innermost loop containing this one, if any
+	Parent *Loop
+}
+
+// Contains returns true if block is part of the loop's body.
+func (self *Loop) Contains(block *BasicBlock) bool {
+	for _, member := range self.Body {
+		if member == block {
+			return true
+		}
+	}
+	return false
+}
+
+// callFlow is the control flow graph with calls to internal functions stepped
+// over: each call site leads straight to its return site, and the edges into
+// and out of the function are dropped, so that a function called from several
+// places isn't mistaken for a loop. Function entries are roots of their own.
+type callFlow struct {
+	// Edges between a call site and a function, or a return and a return site
+	calls       map[*Edge]bool
+	returnSites map[*BasicBlock]*BasicBlock
+	// Blocks that return from a function, which end their flow
+	returns []*BasicBlock
+	preds   map[*BasicBlock][]*BasicBlock
+	idom    map[*BasicBlock]*BasicBlock
+}
+
+func newCallFlow(prog *Program) *callFlow {
+	self := &callFlow{
+		calls:       make(map[*Edge]bool),
+		returnSites: make(map[*BasicBlock]*BasicBlock),
+		preds:       make(map[*BasicBlock][]*BasicBlock),
+	}
+	roots := []*BasicBlock{prog.Entry}
+	for _, function := range prog.InternalFunctions {
+		roots = append(roots, function.Entry)
+		for _, call := range function.Calls {
+			for _, edge := range call.Site.OriginBlock.Successors {
+				if edge.To == function.Entry {
+					self.calls[edge] = true
+				}
+			}
+			self.returnSites[call.Site.OriginBlock] = call.ReturnSite
+		}
+		for _, ret := range function.Returns {
+			for _, edge := range ret.OriginBlock.Successors {
+				self.calls[edge] = true
+			}
+			self.returns = append(self.returns, ret.OriginBlock)
+		}
+	}
+	for _, block := range prog.Blocks {
+		for _, successor := range self.successors(block) {
+			self.preds[successor] = append(self.preds[successor], block)
+		}
+	}
+	self.idom = immediateDominators(roots, self.successors, self.predecessors)
+	return self
+}
+
+func (self *callFlow) successors(block *BasicBlock) []*BasicBlock {
+	var ret []*BasicBlock
+	for _, edge := range block.Successors {
+		if !self.calls[edge] {
+			ret = append(ret, edge.To)
+		}
+	}
+	if returnSite := self.returnSites[block]; returnSite != nil {
+		ret = append(ret, returnSite)
+	}
+	return ret
+}
+
+func (self *callFlow) predecessors(block *BasicBlock) []*BasicBlock {
+	return self.preds[block]
+}
+
+// dominates returns true if a dominates b once calls are stepped over.
+func (self *callFlow) dominates(a, b *BasicBlock) bool {
+	for b != nil {
+		if a == b {
+			return true
+		}
+		b = self.idom[b]
+	}
+	return false
+}
+
+// postDominators returns the immediate post-dominator of each block once calls
+// are stepped over, taking the program's exits and the returns of internal
+// functions as exits. A function's entry never post-dominates its callers.
+func (self *callFlow) postDominators(prog *Program) map[*BasicBlock]*BasicBlock {
+	exits := append([]*BasicBlock{}, prog.Exits...)
+	for _, block := range self.returns {
+		if len(self.successors(block)) == 0 {
+			exits = append(exits, block)
+		}
+	}
+	return immediateDominators(exits, self.predecessors, self.successors)
+}
+
+// PerformLoopAnalysis finds the natural loops in a program, annotating each
+// loop's header block with its Loop. Calls to internal functions are stepped
+// over, so a loop's body doesn't include the functions it calls, and internal
+// function analysis must already have been performed.
+func PerformLoopAnalysis(prog *Program) {
+	if prog.Entry == nil {
+		return
+	}
+	flow := newCallFlow(prog)
+
+	var loops []*Loop
+	for _, header := range prog.Blocks {
+		var backEdges []*Edge
+		for _, edge := range header.Predecessors {
+			if !flow.calls[edge] && flow.dominates(header, edge.From) {
+				backEdges = append(backEdges, edge)
+			}
+		}
+		if len(backEdges) == 0 {
+			continue
+		}
+
+		// The body is the header, plus everything that can reach a back edge
+		// without passing through the header
+		body := map[*BasicBlock]bool{header: true}
+		var pending []*BasicBlock
+		for _, edge := range backEdges {
+			if !body[edge.From] {
+				body[edge.From] = true
+				pending = append(pending, edge.From)
+			}
+		}
+		for len(pending) > 0 {
+			var block *BasicBlock
+			block, pending = pending[len(pending)-1], pending[:len(pending)-1]
+			for _, predecessor := range flow.predecessors(block) {
+				if !body[predecessor] {
+					body[predecessor] = true
+					pending = append(pending, predecessor)
+				}
+			}
+		}
+
+		loop := &Loop{Header: header, BackEdges: backEdges}
+		for _, block := range prog.Blocks {
+			if !body[block] {
+				continue
+			}
+			loop.Body = append(loop.Body, block)
+			for _, edge := range block.Successors {
+				if !body[edge.To] && !flow.calls[edge] {
+					loop.Exits = append(loop.Exits, edge)
+				}
+			}
+		}
+		header.Annotations.Set(&loop)
+		loops = append(loops, loop)
+	}
+
+	// The parent of a loop is the smallest other loop containing its header
+	for _, loop := range loops {
+		for _, other := range loops {
+			if other != loop && other.Contains(loop.Header) && (loop.Parent == nil || len(other.Body) < len(loop.Parent.Body)) {
+				loop.Parent = other
+			}
+		}
+	}
+}
+
+// Loops returns the loops found by PerformLoopAnalysis, ordered by the offset
+// of their headers.
+func Loops(prog *Program) []*Loop {
+	var loops []*Loop
+	for _, block := range prog.Blocks {
+		var loop *Loop
+		block.Annotations.Get(&loop)
+		if loop != nil {
+			loops = append(loops, loop)
+		}
+	}
+	return loops
+}
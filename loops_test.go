@@ -0,0 +1,68 @@
+package evmdis
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDominators(t *testing.T) {
+	// A JUMPI at 0x4 either falls through to 0x5 or jumps to 0x7, which both
+	// paths reach
+	prog := analyzeHex(t, "346007576001505b00", DefaultOptions())
+	entry, merge := prog.Entry, prog.JumpDestinations[0x7]
+	side := entry.Next
+	if side == nil || side == merge {
+		t.Fatalf("the JUMPI's fallthrough has no block of its own")
+	}
+	for _, test := range []struct {
+		name     string
+		relation func(a, b *BasicBlock) bool
+		a, b     *BasicBlock
+		want     bool
+	}{
+		{"entry dominates merge", Dominates, entry, merge, true},
+		{"entry dominates itself", Dominates, entry, entry, true},
+		{"side dominates merge", Dominates, side, merge, false},
+		{"merge dominates entry", Dominates, merge, entry, false},
+		{"merge post-dominates entry", PostDominates, merge, entry, true},
+		{"merge post-dominates side", PostDominates, merge, side, true},
+		{"side post-dominates entry", PostDominates, side, entry, false},
+	} {
+		if got := test.relation(test.a, test.b); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		code  string
+		loops int
+	}{
+		{"infinite loop", "5b600056", 1},
+		{"straight", "60016002015000", 0},
+		// Returns from a helper called twice aren't back edges
+		{"shared helper", sharedHelperCode, 0},
+	} {
+		prog := analyzeHex(t, test.code, DefaultOptions())
+		if loops := Loops(prog); len(loops) != test.loops {
+			t.Errorf("%v: got %d loops, want %d", test.name, len(loops), test.loops)
+		}
+	}
+
+	prog := analyzeHex(t, "5b600056", DefaultOptions())
+	loop := Loops(prog)[0]
+	if loop.Header != prog.JumpDestinations[0x0] || len(loop.Body) != 1 || len(loop.BackEdges) != 1 || len(loop.Exits) != 0 {
+		t.Errorf("unexpected loop %+v", loop)
+	}
+
+	data, err := os.ReadFile("tests/loop.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loops := Loops(analyzeHex(t, strings.TrimSpace(string(data)), DefaultOptions())); len(loops) != 1 {
+		t.Errorf("tests/loop.bin: got %d loops, want 1", len(loops))
+	}
+}
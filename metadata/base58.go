@@ -0,0 +1,33 @@
+package metadata
+
+import (
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// encodeBase58 encodes data using the Bitcoin base58 alphabet, as used for
+// IPFS CIDv0 identifiers.
+func encodeBase58(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	remainder := new(big.Int)
+
+	var encoded []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, remainder)
+		encoded = append(encoded, base58Alphabet[remainder.Int64()])
+	}
+	// Leading zero bytes are encoded as leading '1's
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
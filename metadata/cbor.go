@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CBOR major types, see RFC 7049
+const (
+	cborUnsigned = iota
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+var errTruncated = errors.New("truncated CBOR data")
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeCBOR decodes a single CBOR data item that must occupy all of data.
+// Maps are decoded to map[string]interface{}, arrays to []interface{}, byte
+// strings to []byte, text strings to string, integers to uint64 or int64, and
+// simple values to bool or nil.
+func decodeCBOR(data []byte) (interface{}, error) {
+	decoder := &cborDecoder{data: data}
+	value, err := decoder.decode()
+	if err != nil {
+		return nil, err
+	}
+	if decoder.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing data after CBOR item", len(data)-decoder.pos)
+	}
+	return value, nil
+}
+
+func (self *cborDecoder) readByte() (byte, error) {
+	if self.pos >= len(self.data) {
+		return 0, errTruncated
+	}
+	b := self.data[self.pos]
+	self.pos++
+	return b, nil
+}
+
+func (self *cborDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(self.data)-self.pos) {
+		return nil, errTruncated
+	}
+	b := self.data[self.pos : self.pos+int(n)]
+	self.pos += int(n)
+	return b, nil
+}
+
+// readHeader returns the major type and argument of the next item.
+func (self *cborDecoder) readHeader() (byte, uint64, error) {
+	initial, err := self.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := initial>>5, initial&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		b, err := self.readBytes(1 << (info - 24))
+		if err != nil {
+			return 0, 0, err
+		}
+		var arg uint64
+		for _, v := range b {
+			arg = arg<<8 | uint64(v)
+		}
+		return major, arg, nil
+	case info == 31:
+		return 0, 0, errors.New("indefinite length CBOR items are not supported")
+	}
+	return 0, 0, fmt.Errorf("invalid CBOR additional information %d", info)
+}
+
+func (self *cborDecoder) decode() (interface{}, error) {
+	major, arg, err := self.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		return arg, nil
+	case cborNegative:
+		return -1 - int64(arg), nil
+	case cborBytes:
+		return self.readBytes(arg)
+	case cborText:
+		b, err := self.readBytes(arg)
+		return string(b), err
+	case cborArray:
+		if arg > uint64(len(self.data)) {
+			return nil, errTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := self.decode()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		if arg > uint64(len(self.data)) {
+			return nil, errTruncated
+		}
+		items := make(map[string]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := self.decode()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported CBOR map key %v", key)
+			}
+			if items[name], err = self.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case cborTag:
+		// Tags only add semantics to the item that follows, so skip them
+		return self.decode()
+	case cborSimple:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+	}
+	return nil, fmt.Errorf("invalid CBOR major type %d", major)
+}
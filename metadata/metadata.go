@@ -0,0 +1,180 @@
+// Package metadata decodes the CBOR encoded metadata that solc and Vyper append
+// to contract bytecode. See
+// https://docs.soliditylang.org/en/latest/metadata.html#encoding-of-the-metadata-hash-in-the-bytecode
+package metadata
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Metadata struct {
+	// Multihash of the metadata file in IPFS, if present
+	IPFS []byte
+	// Swarm hash of the metadata file, and the key it was stored under
+	// ("bzzr0" or "bzzr1"), if present
+	Swarm        []byte
+	SwarmVersion string
+	// Compiler that produced the bytecode ("solc" or "vyper") and its version,
+	// if recorded
+	Compiler        string
+	CompilerVersion string
+	Experimental    bool
+	// Sizes Vyper >= 0.3.10 records of the runtime code, its data sections
+	// and its immutables, and the integrity hash of its sources from 0.4.1
+	RuntimeSize   int
+	DataSizes     []int
+	ImmutableSize int
+	IntegrityHash []byte
+	// All of the decoded fields, including ones not recognised above
+	Fields map[string]interface{}
+	// Length of the trailer in the bytecode, including the 2 byte length suffix
+	Length int
+}
+
+// Split separates bytecode into code and its metadata trailer. If the bytecode
+// doesn't end with a valid trailer it is returned unchanged, with nil metadata.
+func Split(bytecode []byte) ([]byte, *Metadata) {
+	bytecodeLength := len(bytecode)
+	if bytecodeLength < 2 {
+		return bytecode, nil
+	}
+
+	// The length excludes the 2 byte suffix, except for Vyper >= 0.3.10
+	length := int(bytecode[bytecodeLength-2])<<8 | int(bytecode[bytecodeLength-1])
+	for _, start := range []int{bytecodeLength - length - 2, bytecodeLength - length} {
+		if length == 0 || start < 0 || start > bytecodeLength-2 {
+			continue
+		}
+		if metadata, err := Decode(bytecode[start : bytecodeLength-2]); err == nil {
+			metadata.Length = bytecodeLength - start
+			return bytecode[:start], metadata
+		}
+	}
+	return bytecode, nil
+}
+
+// Decode decodes CBOR metadata, without the trailing length. This is a map,
+// except for Vyper >= 0.3.10, which emits an array of
+// [integrity hash (from 0.4.1), runtime size, data section sizes, immutables
+// size, map].
+func Decode(data []byte) (*Metadata, error) {
+	value, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &Metadata{
+		Length: len(data) + 2,
+	}
+	if items, ok := value.([]interface{}); ok {
+		if value, err = metadata.decodeSizes(items); err != nil {
+			return nil, err
+		}
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, fmt.Errorf("metadata is not a CBOR map")
+	}
+	metadata.Fields = fields
+
+	for key, value := range fields {
+		switch key {
+		case "ipfs":
+			if metadata.IPFS, ok = value.([]byte); !ok {
+				return nil, fmt.Errorf("ipfs field is not a byte string")
+			}
+		case "bzzr0", "bzzr1":
+			if metadata.Swarm, ok = value.([]byte); !ok {
+				return nil, fmt.Errorf("%v field is not a byte string", key)
+			}
+			metadata.SwarmVersion = key
+		case "solc", "vyper":
+			metadata.Compiler = key
+			if metadata.CompilerVersion, err = decodeVersion(value); err != nil {
+				return nil, fmt.Errorf("%v field: %v", key, err)
+			}
+		case "experimental":
+			metadata.Experimental = value != false
+		}
+	}
+	return metadata, nil
+}
+
+// decodeSizes decodes the items of the array form of Vyper's metadata ahead of
+// its map, which is returned.
+func (self *Metadata) decodeSizes(items []interface{}) (interface{}, error) {
+	if len(items) == 5 {
+		var ok bool
+		if self.IntegrityHash, ok = items[0].([]byte); !ok {
+			return nil, fmt.Errorf("integrity hash is not a byte string")
+		}
+		items = items[1:]
+	}
+	if len(items) != 4 {
+		return nil, fmt.Errorf("metadata array has %d items", len(items))
+	}
+	runtimeSize, ok1 := items[0].(uint64)
+	dataSizes, ok2 := items[1].([]interface{})
+	immutableSize, ok3 := items[2].(uint64)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("metadata array sizes are not integers")
+	}
+	self.RuntimeSize, self.ImmutableSize = int(runtimeSize), int(immutableSize)
+	for _, item := range dataSizes {
+		size, ok := item.(uint64)
+		if !ok {
+			return nil, fmt.Errorf("data section size %v is not an integer", item)
+		}
+		self.DataSizes = append(self.DataSizes, int(size))
+	}
+	return items[3], nil
+}
+
+// decodeVersion decodes a compiler version, which solc encodes as a 3 byte
+// string for releases and a text string for prereleases, and Vyper encodes as
+// an array of integers.
+func decodeVersion(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case []byte:
+		parts := make([]string, len(value))
+		for i, part := range value {
+			parts[i] = fmt.Sprint(part)
+		}
+		return strings.Join(parts, "."), nil
+	case []interface{}:
+		parts := make([]string, len(value))
+		for i, part := range value {
+			parts[i] = fmt.Sprint(part)
+		}
+		return strings.Join(parts, "."), nil
+	}
+	return "", fmt.Errorf("unexpected version %v", value)
+}
+
+// IPFSHash returns the IPFS hash of the metadata file in its usual base58
+// encoded form, or "" if there isn't one.
+func (self *Metadata) IPFSHash() string {
+	if self.IPFS == nil {
+		return ""
+	}
+	return encodeBase58(self.IPFS)
+}
+
+func (self *Metadata) String() string {
+	var parts []string
+	if self.Compiler != "" {
+		parts = append(parts, fmt.Sprintf("%v %v", self.Compiler, self.CompilerVersion))
+	}
+	if self.IPFS != nil {
+		parts = append(parts, fmt.Sprintf("ipfs %v", self.IPFSHash()))
+	}
+	if self.Swarm != nil {
+		parts = append(parts, fmt.Sprintf("%v 0x%x", self.SwarmVersion, self.Swarm))
+	}
+	if self.Experimental {
+		parts = append(parts, "experimental")
+	}
+	return strings.Join(parts, ", ")
+}
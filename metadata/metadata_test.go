@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncodeBase58(t *testing.T) {
+	for _, test := range []struct {
+		data    string
+		encoded string
+	}{
+		{"", ""},
+		{"00", "1"},
+		{"000001", "112"},
+		{hex.EncodeToString([]byte("hello world")), "StV1DL6CwTryKyV"},
+		{"12200102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20", "QmNQatwxYrvx45JHzALe54be3KTBVQrLtHdPfkmvNNhQkw"},
+	} {
+		if got := encodeBase58(decodeHex(t, test.data)); got != test.encoded {
+			t.Errorf("encodeBase58(%v): got %v, want %v", test.data, got, test.encoded)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		value string
+	}{
+		{"17", "23"},
+		{"1903e8", "1000"},
+		{"20", "-1"},
+		{"4301020a", "[1 2 10]"},
+		{"6161", "a"},
+		{"83010203", "[1 2 3]"},
+		{"a1616101", "map[a:1]"},
+		{"f5", "true"},
+		{"f6", "<nil>"},
+	} {
+		value, err := decodeCBOR(decodeHex(t, test.data))
+		if err != nil {
+			t.Errorf("decodeCBOR(%v): %v", test.data, err)
+		} else if got := fmt.Sprint(value); got != test.value {
+			t.Errorf("decodeCBOR(%v): got %v, want %v", test.data, got, test.value)
+		}
+	}
+
+	for _, data := range []string{"", "19", "4301", "a16161", "0101", "ff"} {
+		if value, err := decodeCBOR(decodeHex(t, data)); err == nil {
+			t.Errorf("decodeCBOR(%v): got %v, want an error", data, value)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	code := "6080604052600080fd"
+	for _, test := range []struct {
+		name     string
+		trailer  string
+		compiler string
+		version  string
+		check    func(*Metadata) bool
+	}{
+		{
+			"solc ipfs",
+			"a26469706673582212200102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2064736f6c63430008130033",
+			"solc", "0.8.19",
+			func(m *Metadata) bool {
+				return m.IPFSHash() == "QmNQatwxYrvx45JHzALe54be3KTBVQrLtHdPfkmvNNhQkw"
+			},
+		},
+		{
+			"solc swarm",
+			"a165627a7a7230582070d7df799acac354ad4bd60ad039c33ea5e79ea6b3a18a8e9510e8622feba9bc0029",
+			"", "",
+			func(m *Metadata) bool {
+				return m.SwarmVersion == "bzzr0" && len(m.Swarm) == 32
+			},
+		},
+		{
+			"vyper map",
+			"a165767970657283000307000b",
+			"vyper", "0.3.7",
+			func(m *Metadata) bool { return m.RuntimeSize == 0 },
+		},
+		{
+			"vyper 0.3.10 array",
+			"8419012381182000a16576797065728300030a0015",
+			"vyper", "0.3.10",
+			func(m *Metadata) bool {
+				return m.RuntimeSize == 0x123 && len(m.DataSizes) == 1 && m.DataSizes[0] == 0x20 && m.IntegrityHash == nil
+			},
+		},
+		{
+			"vyper 0.4.1 array",
+			"855820aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa1901238000a1657679706572830004010035",
+			"vyper", "0.4.1",
+			func(m *Metadata) bool {
+				return m.RuntimeSize == 0x123 && len(m.DataSizes) == 0 && bytes.Equal(m.IntegrityHash, bytes.Repeat([]byte{0xaa}, 32))
+			},
+		},
+	} {
+		bytecode := decodeHex(t, code+test.trailer)
+		split, meta := Split(bytecode)
+		if meta == nil {
+			t.Errorf("%v: no metadata found", test.name)
+			continue
+		}
+		if hex.EncodeToString(split) != code {
+			t.Errorf("%v: code is %x, want %v", test.name, split, code)
+		}
+		if meta.Length != len(test.trailer)/2 {
+			t.Errorf("%v: length is %d, want %d", test.name, meta.Length, len(test.trailer)/2)
+		}
+		if meta.Compiler != test.compiler || meta.CompilerVersion != test.version {
+			t.Errorf("%v: compiler is %v %v, want %v %v", test.name, meta.Compiler, meta.CompilerVersion, test.compiler, test.version)
+		}
+		if !test.check(meta) {
+			t.Errorf("%v: unexpected metadata %+v", test.name, meta)
+		}
+	}
+
+	// Code without a trailer is left as it is
+	for _, data := range []string{"", "00", code, code + "0002"} {
+		if split, meta := Split(decodeHex(t, data)); meta != nil || hex.EncodeToString(split) != data {
+			t.Errorf("Split(%v): got %x and %v, want no metadata", data, split, meta)
+		}
+	}
+}
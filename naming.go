@@ -0,0 +1,71 @@
+package evmdis
+
+import (
+	"strings"
+
+	"github.com/Arachnid/evmdis/signatures"
+)
+
+// Signatures annotates an instruction that compares against a function selector,
+// or logs an event, with the signatures the selector or topic may have been
+// derived from.
+type Signatures []string
+
+// PerformSignatureAnalysis names the functions found by dispatcher analysis and
+// the events logged by the program using resolver. The instructions that compare
+// against each selector and that log each event are annotated with their
+// Signatures. Dispatcher analysis must already have been performed.
+func PerformSignatureAnalysis(prog *Program, resolver signatures.Resolver) {
+	if prog.Dispatcher != nil {
+		for _, function := range prog.Dispatcher.Functions {
+			function.Signatures = resolver.Functions(function.Selector)
+			if len(function.Signatures) > 0 {
+				names := Signatures(function.Signatures)
+				function.Comparison.Get().Annotations.Set(&names)
+			}
+		}
+	}
+
+	for _, block := range prog.Blocks {
+		for i := range block.Instructions {
+			inst := &block.Instructions[i]
+			if inst.Op < LOG1 || inst.Op > LOG4 {
+				continue
+			}
+			// The first topic of a LOG is the event's signature
+			value := constantOperand(InstructionPointer{block, i}, 2)
+			if value == nil {
+				continue
+			}
+			var topic [32]byte
+			value.FillBytes(topic[:])
+			if names := Signatures(resolver.Events(topic)); len(names) > 0 {
+				inst.Annotations.Set(&names)
+			}
+		}
+	}
+}
+
+// ExpressionSignatures returns the signatures annotating the instructions that
+// make up an expression, in the order they appear. Alternative signatures for
+// the same instruction are joined with "or".
+func ExpressionSignatures(expression Expression) []string {
+	ie, ok := expression.(*InstructionExpression)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, arg := range ie.Arguments {
+		names = append(names, ExpressionSignatures(arg)...)
+	}
+	// Synthetic instructions, such as negated conditions, aren't annotated
+	if ie.Inst.Annotations == nil {
+		return names
+	}
+	var annotation Signatures
+	ie.Inst.Annotations.Get(&annotation)
+	if len(annotation) > 0 {
+		names = append(names, strings.Join(annotation, " or "))
+	}
+	return names
+}
@@ -8,7 +8,7 @@ type OpCode byte
 
 func (op OpCode) IsPush() bool {
 	switch op {
-	case PUSH1, PUSH2, PUSH3, PUSH4, PUSH5, PUSH6, PUSH7, PUSH8, PUSH9, PUSH10, PUSH11, PUSH12, PUSH13, PUSH14, PUSH15, PUSH16, PUSH17, PUSH18, PUSH19, PUSH20, PUSH21, PUSH22, PUSH23, PUSH24, PUSH25, PUSH26, PUSH27, PUSH28, PUSH29, PUSH30, PUSH31, PUSH32:
+	case PUSH0, PUSH1, PUSH2, PUSH3, PUSH4, PUSH5, PUSH6, PUSH7, PUSH8, PUSH9, PUSH10, PUSH11, PUSH12, PUSH13, PUSH14, PUSH15, PUSH16, PUSH17, PUSH18, PUSH19, PUSH20, PUSH21, PUSH22, PUSH23, PUSH24, PUSH25, PUSH26, PUSH27, PUSH28, PUSH29, PUSH30, PUSH31, PUSH32:
 		return true
 	}
 	return false
@@ -16,30 +16,44 @@ func (op OpCode) IsPush() bool {
 
 func (op OpCode) HasSideEffects() bool {
 	switch op {
-	case CALL:
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2:
+		return true
+	case EXTCALL, EXTDELEGATECALL, EXTSTATICCALL, EOFCREATE, CALLF:
 		return true
 	}
 	return false
 }
 
+// IsDup returns true for the DUP instructions, including DUPN, whose depth
+// comes from its immediate; see Program.StackReads.
 func (op OpCode) IsDup() bool {
 	switch op {
-	case DUP1, DUP2, DUP3, DUP4, DUP5, DUP6, DUP7, DUP8, DUP9, DUP10, DUP11, DUP12, DUP13, DUP14, DUP15, DUP16:
+	case DUPN, DUP1, DUP2, DUP3, DUP4, DUP5, DUP6, DUP7, DUP8, DUP9, DUP10, DUP11, DUP12, DUP13, DUP14, DUP15, DUP16:
 		return true
 	}
 	return false
 }
 
+// IsSwap returns true for the SWAP instructions, including SWAPN. EXCHANGE,
+// which swaps two items below the top of the stack, isn't included.
 func (op OpCode) IsSwap() bool {
 	switch op {
-	case SWAP1, SWAP2, SWAP3, SWAP4, SWAP5, SWAP6, SWAP7, SWAP8, SWAP9, SWAP10, SWAP11, SWAP12, SWAP13, SWAP14, SWAP15, SWAP16:
+	case SWAPN, SWAP1, SWAP2, SWAP3, SWAP4, SWAP5, SWAP6, SWAP7, SWAP8, SWAP9, SWAP10, SWAP11, SWAP12, SWAP13, SWAP14, SWAP15, SWAP16:
 		return true
 	}
 	return false
 }
 
+// OperandSize returns the number of bytes of immediate data that follow op. For
+// RJUMPV this is only the size of the table length; see Instruction.Size.
 func (op OpCode) OperandSize() int {
-	if !op.IsPush() {
+	switch op {
+	case RJUMP, RJUMPI, CALLF, JUMPF, DATALOADN:
+		return 2
+	case RJUMPV, EOFCREATE, RETURNCONTRACT, DUPN, SWAPN, EXCHANGE:
+		return 1
+	}
+	if !op.IsPush() || op == PUSH0 {
 		return 0
 	}
 
@@ -50,6 +64,15 @@ func (op OpCode) IsJump() bool {
 	return op == JUMP || op == JUMPI
 }
 
+// Halts returns true if op unconditionally ends execution.
+func (op OpCode) Halts() bool {
+	switch op {
+	case STOP, RETURN, REVERT, INVALID, SELFDESTRUCT, RETURNCONTRACT:
+		return true
+	}
+	return false
+}
+
 const (
 	// 0x0 range - arithmetic ops
 	STOP OpCode = iota
@@ -117,6 +140,8 @@ const (
 	CHAINID
 	SELFBALANCE
 	BASEFEE
+	BLOBHASH
+	BLOBBASEFEE
 )
 
 const (
@@ -133,6 +158,10 @@ const (
 	MSIZE
 	GAS
 	JUMPDEST
+	TLOAD
+	TSTORE
+	MCOPY
+	PUSH0
 )
 
 const (
@@ -203,6 +232,30 @@ const (
 	SWAP16
 )
 
+const (
+	// 0xd0 range - EOF data section access
+	DATALOAD OpCode = 0xd0 + iota
+	DATALOADN
+	DATASIZE
+	DATACOPY
+)
+
+const (
+	// 0xe0 range - EOF control flow
+	RJUMP OpCode = 0xe0 + iota
+	RJUMPI
+	RJUMPV
+	CALLF
+	RETF
+	JUMPF
+	DUPN
+	SWAPN
+	EXCHANGE
+
+	EOFCREATE      = 0xec
+	RETURNCONTRACT = 0xee
+)
+
 const (
 	LOG0 OpCode = 0xa0 + iota
 	LOG1
@@ -220,9 +273,16 @@ const (
 	DELEGATECALL
 	CREATE2
 
+	STATICCALL   = 0xfa
 	INVALID      = 0xfe
 	REVERT       = 0xfd
 	SELFDESTRUCT = 0xff
+
+	// EOF calls
+	RETURNDATALOAD  = 0xf7
+	EXTCALL         = 0xf8
+	EXTDELEGATECALL = 0xf9
+	EXTSTATICCALL   = 0xfb
 )
 
 // Since the opcodes aren't all in order we can't use a regular slice
@@ -283,6 +343,8 @@ var opCodeToString = map[OpCode]string{
 	CHAINID:        "CHAINID",
 	SELFBALANCE:    "SELFBALANCE",
 	BASEFEE:        "BASEFEE",
+	BLOBHASH:       "BLOBHASH",
+	BLOBBASEFEE:    "BLOBBASEFEE",
 	EXTCODESIZE:    "EXTCODESIZE",
 	EXTCODECOPY:    "EXTCODECOPY",
 	RETURNDATASIZE: "RETURNDATASIZE",
@@ -304,8 +366,12 @@ var opCodeToString = map[OpCode]string{
 	MSIZE:    "MSIZE",
 	GAS:      "GAS",
 	JUMPDEST: "JUMPDEST",
+	TLOAD:    "TLOAD",
+	TSTORE:   "TSTORE",
+	MCOPY:    "MCOPY",
 
 	// 0x60 range - push
+	PUSH0:  "PUSH0",
 	PUSH1:  "PUSH1",
 	PUSH2:  "PUSH2",
 	PUSH3:  "PUSH3",
@@ -384,10 +450,36 @@ var opCodeToString = map[OpCode]string{
 	RETURN:       "RETURN",
 	CALLCODE:     "CALLCODE",
 	DELEGATECALL: "DELEGATECALL",
+	STATICCALL:   "STATICCALL",
 	INVALID:      "INVALID",
 	REVERT:       "REVERT",
 	SELFDESTRUCT: "SELFDESTRUCT",
 	CREATE2:      "CREATE2",
+
+	// 0xd0 range - EOF data section access
+	DATALOAD:  "DATALOAD",
+	DATALOADN: "DATALOADN",
+	DATASIZE:  "DATASIZE",
+	DATACOPY:  "DATACOPY",
+
+	// 0xe0 range - EOF control flow
+	RJUMP:          "RJUMP",
+	RJUMPI:         "RJUMPI",
+	RJUMPV:         "RJUMPV",
+	CALLF:          "CALLF",
+	RETF:           "RETF",
+	JUMPF:          "JUMPF",
+	DUPN:           "DUPN",
+	SWAPN:          "SWAPN",
+	EXCHANGE:       "EXCHANGE",
+	EOFCREATE:      "EOFCREATE",
+	RETURNCONTRACT: "RETURNCONTRACT",
+
+	// EOF calls
+	RETURNDATALOAD:  "RETURNDATALOAD",
+	EXTCALL:         "EXTCALL",
+	EXTDELEGATECALL: "EXTDELEGATECALL",
+	EXTSTATICCALL:   "EXTSTATICCALL",
 }
 
 func (o OpCode) String() string {
@@ -417,7 +509,7 @@ var opCodeToStackReads = map[OpCode]int{
 	SGT:        2,
 	EQ:         2,
 	ISZERO:     1,
-	SIGNEXTEND: 1,
+	SIGNEXTEND: 2,
 
 	// 0x10 range - bit ops
 	AND:    2,
@@ -456,6 +548,8 @@ var opCodeToStackReads = map[OpCode]int{
 	CHAINID:        0,
 	SELFBALANCE:    0,
 	BASEFEE:        0,
+	BLOBHASH:       1,
+	BLOBBASEFEE:    0,
 	EXTCODESIZE:    1,
 	EXTCODECOPY:    4,
 	RETURNDATASIZE: 0,
@@ -477,8 +571,12 @@ var opCodeToStackReads = map[OpCode]int{
 	MSIZE:    0,
 	GAS:      0,
 	JUMPDEST: 0,
+	TLOAD:    1,
+	TSTORE:   2,
+	MCOPY:    3,
 
 	// 0x60 range - push
+	PUSH0:  0,
 	PUSH1:  0,
 	PUSH2:  0,
 	PUSH3:  0,
@@ -557,10 +655,38 @@ var opCodeToStackReads = map[OpCode]int{
 	RETURN:       2,
 	CALLCODE:     7,
 	DELEGATECALL: 6,
+	STATICCALL:   6,
 	INVALID:      0,
-	REVERT:       0,
+	REVERT:       2,
 	SELFDESTRUCT: 1,
 	CREATE2:      4,
+
+	// 0xd0 range - EOF data section access
+	DATALOAD:  1,
+	DATALOADN: 0,
+	DATASIZE:  0,
+	DATACOPY:  3,
+
+	// 0xe0 range - EOF control flow; the stack effects of CALLF, RETF and
+	// JUMPF depend on the types of the code sections involved, and those of
+	// DUPN, SWAPN and EXCHANGE on their immediates
+	RJUMP:          0,
+	RJUMPI:         1,
+	RJUMPV:         1,
+	CALLF:          0,
+	RETF:           0,
+	JUMPF:          0,
+	DUPN:           0,
+	SWAPN:          0,
+	EXCHANGE:       0,
+	EOFCREATE:      4,
+	RETURNCONTRACT: 2,
+
+	// EOF calls
+	RETURNDATALOAD:  1,
+	EXTCALL:         4,
+	EXTDELEGATECALL: 3,
+	EXTSTATICCALL:   3,
 }
 
 func (o OpCode) StackReads() int {
@@ -624,6 +750,8 @@ var opCodeToStackWrites = map[OpCode]int{
 	CHAINID:        1,
 	SELFBALANCE:    1,
 	BASEFEE:        1,
+	BLOBHASH:       1,
+	BLOBBASEFEE:    1,
 	EXTCODESIZE:    1,
 	EXTCODECOPY:    0,
 	RETURNDATASIZE: 1,
@@ -645,8 +773,12 @@ var opCodeToStackWrites = map[OpCode]int{
 	MSIZE:    1,
 	GAS:      1,
 	JUMPDEST: 0,
+	TLOAD:    1,
+	TSTORE:   0,
+	MCOPY:    0,
 
 	// 0x60 range - push
+	PUSH0:  1,
 	PUSH1:  1,
 	PUSH2:  1,
 	PUSH3:  1,
@@ -725,10 +857,36 @@ var opCodeToStackWrites = map[OpCode]int{
 	RETURN:       0,
 	CALLCODE:     1,
 	DELEGATECALL: 1,
+	STATICCALL:   1,
 	INVALID:      0,
 	REVERT:       0,
 	SELFDESTRUCT: 0,
 	CREATE2:      1,
+
+	// 0xd0 range - EOF data section access
+	DATALOAD:  1,
+	DATALOADN: 1,
+	DATASIZE:  1,
+	DATACOPY:  0,
+
+	// 0xe0 range - EOF control flow
+	RJUMP:          0,
+	RJUMPI:         0,
+	RJUMPV:         0,
+	CALLF:          0,
+	RETF:           0,
+	JUMPF:          0,
+	DUPN:           0,
+	SWAPN:          0,
+	EXCHANGE:       0,
+	EOFCREATE:      1,
+	RETURNCONTRACT: 0,
+
+	// EOF calls
+	RETURNDATALOAD:  1,
+	EXTCALL:         1,
+	EXTDELEGATECALL: 1,
+	EXTSTATICCALL:   1,
 }
 
 func (o OpCode) StackWrites() int {
@@ -784,6 +942,8 @@ var stringToOp = map[string]OpCode{
 	"CHAINID":        CHAINID,
 	"SELFBALANCE":    SELFBALANCE,
 	"BASEFEE":        BASEFEE,
+	"BLOBHASH":       BLOBHASH,
+	"BLOBBASEFEE":    BLOBBASEFEE,
 	"EXTCODESIZE":    EXTCODESIZE,
 	"EXTCODECOPY":    EXTCODECOPY,
 	"RETURNDATASIZE": RETURNDATASIZE,
@@ -801,6 +961,10 @@ var stringToOp = map[string]OpCode{
 	"MSIZE":          MSIZE,
 	"GAS":            GAS,
 	"JUMPDEST":       JUMPDEST,
+	"TLOAD":          TLOAD,
+	"TSTORE":         TSTORE,
+	"MCOPY":          MCOPY,
+	"PUSH0":          PUSH0,
 	"PUSH1":          PUSH1,
 	"PUSH2":          PUSH2,
 	"PUSH3":          PUSH3,
@@ -878,6 +1042,27 @@ var stringToOp = map[string]OpCode{
 	"REVERT":         REVERT,
 	"SELFDESTRUCT":   SELFDESTRUCT,
 	"CREATE2":        CREATE2,
+	"STATICCALL":     STATICCALL,
+
+	"DATALOAD":        DATALOAD,
+	"DATALOADN":       DATALOADN,
+	"DATASIZE":        DATASIZE,
+	"DATACOPY":        DATACOPY,
+	"RJUMP":           RJUMP,
+	"RJUMPI":          RJUMPI,
+	"RJUMPV":          RJUMPV,
+	"CALLF":           CALLF,
+	"RETF":            RETF,
+	"JUMPF":           JUMPF,
+	"DUPN":            DUPN,
+	"SWAPN":           SWAPN,
+	"EXCHANGE":        EXCHANGE,
+	"EOFCREATE":       EOFCREATE,
+	"RETURNCONTRACT":  RETURNCONTRACT,
+	"RETURNDATALOAD":  RETURNDATALOAD,
+	"EXTCALL":         EXTCALL,
+	"EXTDELEGATECALL": EXTDELEGATECALL,
+	"EXTSTATICCALL":   EXTSTATICCALL,
 }
 
 func StringToOp(str string) OpCode {
@@ -1,10 +1,15 @@
 package evmdis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/Arachnid/evmdis/stack"
 	"log"
+	"math/big"
+	"sort"
 	"strings"
+	"time"
 )
 
 type InstructionPointer struct {
@@ -19,7 +24,7 @@ func (self InstructionPointer) Get() *Instruction {
 func (self InstructionPointer) GetAddress() int {
 	address := self.OriginBlock.Offset
 	for i := 0; i < self.OriginIndex; i++ {
-		address += self.OriginBlock.Instructions[i].Op.OperandSize() + 1
+		address += self.OriginBlock.Instructions[i].Size()
 	}
 	return address
 }
@@ -37,6 +42,8 @@ func (self InstructionPointer) String() string {
 		break;
 	case *JumpLabel:
 		return fmt.Sprintf("%v", expression)
+	case *InputExpression:
+		return fmt.Sprintf("%v", expression)
 	}
 
 	return fmt.Sprintf("@0x%X", self.GetAddress())
@@ -46,7 +53,7 @@ type InstructionPointerSet map[InstructionPointer]bool
 
 func (self InstructionPointerSet) String() string {
 	pointers := make([]string, 0)
-	for k := range self {
+	for _, k := range self.Sorted() {
 		pointers = append(pointers, k.String())
 	}
 	if len(pointers) == 1 {
@@ -56,6 +63,22 @@ func (self InstructionPointerSet) String() string {
 	}
 }
 
+// Sorted returns the pointers in the set, ordered by address.
+func (self InstructionPointerSet) Sorted() []InstructionPointer {
+	pointers := make([]InstructionPointer, 0, len(self))
+	for k := range self {
+		pointers = append(pointers, k)
+	}
+	sort.Slice(pointers, func(i, j int) bool {
+		a, b := pointers[i].GetAddress(), pointers[j].GetAddress()
+		if a != b {
+			return a < b
+		}
+		return pointers[i].OriginBlock.Offset < pointers[j].OriginBlock.Offset
+	})
+	return pointers
+}
+
 func (self InstructionPointerSet) First() *InstructionPointer {
 	for pointer, _ := range self {
 		return &pointer
@@ -65,24 +88,187 @@ func (self InstructionPointerSet) First() *InstructionPointer {
 
 type ReachingDefinition []InstructionPointerSet
 
+// Each stack slot holds the InstructionPointer that defined it, or a
+// *joinedDefinition if states were widened.
 type reachingState struct {
-	program   *Program
-	nextBlock *BasicBlock
-	stack     stack.StackFrame
+	program      *Program
+	nextBlock    *BasicBlock
+	stack        stack.StackFrame
+	contextDepth int
+	joins        *reachingJoins
+	// Set once the stack has been truncated by widening, after which it may
+	// underflow where the program's doesn't, stopping the analysis with a
+	// WideningError
+	widened bool
+}
+
+// ReachingOptions configures the reaching analysis.
+type ReachingOptions struct {
+	// Number of return addresses on the stack that distinguish the calling
+	// contexts a block is reached in. If non-zero, blocks and instructions
+	// are also annotated with a ContextReachingDefinition holding their
+	// reaching definitions in each context separately; ReachingDefinition
+	// always summarises every context.
+	ContextDepth int
+	// Bounds on the exploration done by the analysis
+	Execution ExecutionOptions
+}
+
+// DefaultReachingOptions returns the options PerformReachingAnalysis uses,
+// which bound the analysis so that it finishes on any program: at most a
+// million states or a minute, widening blocks reached with more than 64
+// distinct stacks.
+func DefaultReachingOptions() ReachingOptions {
+	return ReachingOptions{
+		Execution: ExecutionOptions{
+			MaxStates:  1000000,
+			Timeout:    time.Minute,
+			WidenAfter: 64,
+		},
+	}
+}
+
+// WideningError is returned by the reaching analysis when a stack that was cut
+// down by widening underflows. The definitions the instruction would have read
+// were lost, so the analysis stops rather than carry on without them.
+type WideningError struct {
+	Block  *BasicBlock
+	Offset int
+	Op     OpCode
+	Reads  int
+	Height int
+}
+
+func (self *WideningError) Error() string {
+	return fmt.Sprintf("Stack underflow after widening at 0x%X: %v reads %v items, but the stack has %v", self.Offset, self.Op, self.Reads, self.Height)
 }
 
 func PerformReachingAnalysis(prog *Program) error {
+	return PerformReachingAnalysisWithOptions(prog, DefaultReachingOptions())
+}
+
+// PerformReachingAnalysisWithOptions performs reaching analysis as configured
+// by options.
+func PerformReachingAnalysisWithOptions(prog *Program, options ReachingOptions) error {
+	return PerformReachingAnalysisContext(context.Background(), prog, options)
+}
+
+// PerformReachingAnalysisContext is PerformReachingAnalysisWithOptions,
+// stopping early with ctx.Err() if ctx is cancelled. The control flow graph and
+// annotations found until then are kept.
+func PerformReachingAnalysisContext(ctx context.Context, prog *Program, options ReachingOptions) error {
+	if len(prog.Blocks) == 0 {
+		return fmt.Errorf("Program contains no code")
+	}
+	var inputs stack.StackFrame = stack.StackEnd{}
+	if prog.Inputs != nil {
+		for i := range prog.Inputs.Instructions {
+			inputs = stack.NewFrame(inputs, InstructionPointer{prog.Inputs, i})
+		}
+	}
+	prog.resetEdges()
+	prog.Entry = prog.Blocks[0]
 	initial := reachingState{
-		program:   prog,
-		nextBlock: prog.Blocks[0],
-		stack:     stack.StackEnd{},
+		program:      prog,
+		nextBlock:    prog.Entry,
+		stack:        inputs,
+		contextDepth: options.ContextDepth,
+		joins:        &reachingJoins{make(map[joinSlot]*joinedDefinition)},
+	}
+	// Jump targets are folded from definitions whose reaching definitions may
+	// still grow after the jump has been explored, so repeat the analysis
+	// until every jump has been followed to all of its targets. Every pass is
+	// charged to the same budget.
+	var err error
+	start := time.Now()
+	budget := options.Execution
+	explored := 0
+	for {
+		var states int
+		states, err = executeAbstractly(ctx, initial, budget)
+		explored += states
+		if err != nil || !prog.hasMissedJumps() {
+			break
+		}
+		if options.Execution.MaxStates > 0 {
+			budget.MaxStates = options.Execution.MaxStates - explored
+		}
+		if options.Execution.Timeout > 0 {
+			budget.Timeout = options.Execution.Timeout - time.Since(start)
+		}
+		if (options.Execution.MaxStates > 0 && budget.MaxStates <= 0) || (options.Execution.Timeout > 0 && budget.Timeout <= 0) {
+			err = &TruncatedError{}
+			break
+		}
+	}
+	prog.incomplete = err != nil
+	// Keep what was found, but say that it's incomplete
+	var truncated *TruncatedError
+	var widening *WideningError
+	if errors.As(err, &truncated) {
+		truncated.States, truncated.Elapsed = explored, time.Since(start)
+		prog.Diagnostics.Warnf(AnalysisReaching, nil, -1, "%v; results are incomplete", truncated)
+		err = nil
+	} else if errors.As(err, &widening) {
+		prog.Diagnostics.Errorf(AnalysisReaching, widening.Block, widening.Offset, "Stack underflow after widening: %v reads %v items, but the stack has %v; results are incomplete", widening.Op, widening.Reads, widening.Height)
+		err = nil
+	}
+	prog.findExits()
+	return err
+}
+
+// hasMissedJumps returns true if a reachable jump may reach a block it hasn't
+// been followed to, given what's now known of the definitions of its target.
+func (self *Program) hasMissedJumps() bool {
+	for _, block := range self.Blocks {
+		if len(block.Instructions) == 0 {
+			continue
+		}
+		inst := &block.Instructions[len(block.Instructions)-1]
+		var reaching ReachingDefinition
+		inst.Annotations.Get(&reaching)
+		if !inst.Op.IsJump() || len(reaching) == 0 {
+			continue
+		}
+
+		followed := make(map[*BasicBlock]bool)
+		for _, edge := range block.Successors {
+			if edge.Kind != EdgeConditionalFalse {
+				followed[edge.To] = true
+			}
+		}
+		for definition := range reaching[0] {
+			values := foldConstant(definition, 0)
+			if values == nil {
+				for _, dest := range self.JumpDestinations {
+					if !followed[dest] {
+						return true
+					}
+				}
+			}
+			for _, value := range values {
+				if !value.IsInt64() {
+					continue
+				}
+				if dest := self.JumpDestinations[int(value.Int64())]; dest != nil && !followed[dest] {
+					return true
+				}
+			}
+		}
 	}
-	return ExecuteAbstractly(initial)
+	return false
 }
 
 func updateBlockReachings(block *BasicBlock, stack stack.StackFrame) {
 	var reachings ReachingDefinition
 	block.Annotations.Get(&reachings)
+	reachings = mergeBlockReachings(reachings, stack)
+	block.Annotations.Set(&reachings)
+}
+
+// mergeBlockReachings adds the definitions on stack to those reaching the start
+// of a block.
+func mergeBlockReachings(reachings ReachingDefinition, stack stack.StackFrame) ReachingDefinition {
 	if reachings == nil {
 		reachings = make([]InstructionPointerSet, stack.Height())
 		for i := 0; i < len(reachings); i++ {
@@ -95,20 +281,27 @@ func updateBlockReachings(block *BasicBlock, stack stack.StackFrame) {
 		if len(reachings) <= i {
 			break
 		}
-		reachings[i][frame.Value().(InstructionPointer)] = true
+		for _, definition := range stackDefinitions(frame.Value()) {
+			reachings[i][definition] = true
+		}
 		frame = frame.Up()
 	}
 
 	if stack.Height() < len(reachings) {
 		reachings = reachings[:stack.Height()]
 	}
-
-	block.Annotations.Set(&reachings)
+	return reachings
 }
 
-func updateReachings(inst *Instruction, operands []InstructionPointer) {
+func updateReachings(inst *Instruction, operands []interface{}) {
 	var reachings ReachingDefinition
 	inst.Annotations.Get(&reachings)
+	reachings = mergeReachings(reachings, operands)
+	inst.Annotations.Set(&reachings)
+}
+
+// mergeReachings adds operands to the definitions reaching an instruction.
+func mergeReachings(reachings ReachingDefinition, operands []interface{}) ReachingDefinition {
 	if reachings == nil {
 		reachings = make([]InstructionPointerSet, len(operands))
 		for i := 0; i < len(reachings); i++ {
@@ -117,110 +310,337 @@ func updateReachings(inst *Instruction, operands []InstructionPointer) {
 	}
 
 	for i, operand := range operands {
-		reachings[i][operand] = true
+		for _, definition := range stackDefinitions(operand) {
+			reachings[i][definition] = true
+		}
 	}
-	inst.Annotations.Set(&reachings)
+	return reachings
 }
 
 func (self reachingState) Advance() ([]EvmState, error) {
 	log.Printf("Entering block at %d with stack height %v", self.nextBlock.Offset, self.stack.Height())
 	updateBlockReachings(self.nextBlock, self.stack)
+	var callContext CallContext
+	if self.contextDepth > 0 {
+		callContext = self.callContext()
+		updateBlockContextReachings(self.nextBlock, callContext, self.stack)
+	}
 	pc := self.nextBlock.Offset
 	st := self.stack
 	for i := range self.nextBlock.Instructions {
 		inst := &self.nextBlock.Instructions[i]
 		op := inst.Op
-		opFrames, newStack := stack.Popn(st, op.StackReads())
-		operands := make([]InstructionPointer, len(opFrames))
+		if st.Height() < self.program.StackReads(inst) {
+			if self.widened {
+				return nil, &WideningError{self.nextBlock, pc, op, self.program.StackReads(inst), st.Height()}
+			}
+			self.program.Diagnostics.Errorf(AnalysisReaching, self.nextBlock, pc, "Stack underflow: %v reads %v items, but the stack has %v", op, self.program.StackReads(inst), st.Height())
+			return nil, nil
+		}
+		opFrames, newStack := stack.Popn(st, self.program.StackReads(inst))
+		operands := make([]interface{}, len(opFrames))
 		for i, frame := range opFrames {
-			operands[i] = frame.Value().(InstructionPointer)
+			operands[i] = frame.Value()
 		}
 		updateReachings(inst, operands)
+		if self.contextDepth > 0 {
+			updateContextReachings(inst, callContext, operands)
+		}
 
 		switch true {
 		// Ops that terminate execution
-		case op == STOP:
-			fallthrough
-		case op == RETURN:
-			fallthrough
-		case op == INVALID:
-			fallthrough
-		case op == REVERT:
-			fallthrough
-		case op == SELFDESTRUCT:
+		case op.Halts():
 			return nil, nil
 		case op.IsPush():
 			newStack = stack.NewFrame(newStack, InstructionPointer{self.nextBlock, i})
 		case op.IsDup():
 			// Uses stack instead of newStack, because we don't actually want to pop all those elements
-			newStack = stack.NewFrame(st, stack.UpBy(st, op.StackReads()-1).Value())
+			newStack = stack.NewFrame(st, stack.UpBy(st, self.program.StackReads(inst)-1).Value())
 		case op.IsSwap():
 			// Uses stack instead of newStack, because we don't actually want to pop all those elements
-			newStack = stack.Swap(st, op.StackReads()-1)
-		case op == JUMP:
-			if !operands[0].Get().Op.IsPush() {
-				return nil, fmt.Errorf("%v: Could not determine jump location statically; source is %v", pc, operands[0].GetAddress())
+			newStack = stack.Swap(st, self.program.StackReads(inst)-1)
+		case op == EXCHANGE:
+			first, second := exchangeDepths(inst)
+			newStack = stack.Exchange(st, first, second)
+		case op == JUMP || op == JUMPI:
+			var ret []EvmState
+			dests, resolved := self.jumpDestinations(pc, operands[0])
+			kind := EdgeJump
+			if !resolved {
+				kind = EdgeUnresolved
+			} else if op == JUMPI {
+				kind = EdgeConditionalTrue
 			}
-			if dest, ok := self.program.JumpDestinations[int(operands[0].Get().Arg.Int64())]; ok {
-				return []EvmState{
-					reachingState{
-						program:   self.program,
-						nextBlock: dest,
-						stack:     newStack,
-					},
-				}, nil
+			for _, dest := range dests {
+				ret = append(ret, self.follow(dest, kind, newStack))
 			}
-			return nil, nil
-		case op == JUMPI:
-			if !operands[0].Get().Op.IsPush() {
-				return nil, fmt.Errorf("%v: Could not determine jump location statically; source is %v", pc, operands[0].GetAddress())
+			if op == JUMPI && self.nextBlock.Next != nil {
+				ret = append(ret, self.follow(self.nextBlock.Next, EdgeConditionalFalse, newStack))
 			}
+			return ret, nil
+		case op == RETF || op == JUMPF:
+			// Returns and tail calls leave the code section
+			return nil, nil
+		case op == RJUMP || op == RJUMPI || op == RJUMPV:
 			var ret []EvmState
-			if dest, ok := self.program.JumpDestinations[int(operands[0].Get().Arg.Int64())]; ok {
-				ret = append(ret, reachingState{
-					program:   self.program,
-					nextBlock: dest,
-					stack:     newStack,
-				})
+			kind := EdgeConditionalTrue
+			if op == RJUMP {
+				kind = EdgeJump
 			}
-			if self.nextBlock.Next != nil {
-				ret = append(ret, reachingState{
-					program:   self.program,
-					nextBlock: self.nextBlock.Next,
-					stack:     newStack,
-				})
+			for _, dest := range self.nextBlock.StaticTargets {
+				ret = append(ret, self.follow(dest, kind, newStack))
+			}
+			if op != RJUMP && self.nextBlock.Next != nil {
+				ret = append(ret, self.follow(self.nextBlock.Next, EdgeConditionalFalse, newStack))
 			}
 			return ret, nil
+		case op == CALLF:
+			// Each output of the called section is defined by the call
+			for j := 0; j < self.program.StackWrites(inst); j++ {
+				newStack = stack.NewFrame(newStack, InstructionPointer{self.nextBlock, i})
+			}
 		default:
 			if op.StackWrites() == 1 {
 				newStack = stack.NewFrame(newStack, InstructionPointer{self.nextBlock, i})
 			} else if op.StackWrites() > 1 {
-				return nil, fmt.Errorf("Unexpected op %v makes %v writes to the stack", op, op.StackWrites())
+				self.program.Diagnostics.Errorf(AnalysisReaching, self.nextBlock, pc, "Unexpected op %v makes %v writes to the stack", op, op.StackWrites())
+				return nil, nil
 			}
 		}
 
 		// If the stack is too deep, abort
 		if st.Height() > 1024 {
+			self.program.Diagnostics.Warnf(AnalysisReaching, self.nextBlock, pc, "Stack overflow")
 			return nil, nil
 		}
 
-		pc += op.OperandSize() + 1
+		pc += inst.Size()
 		st = newStack
 	}
 
 	if self.nextBlock.Next != nil {
-		return []EvmState{
-			reachingState{
-				program:   self.program,
-				nextBlock: self.nextBlock.Next,
-				stack:     st,
-			},
-		}, nil
+		return []EvmState{self.follow(self.nextBlock.Next, EdgeFallthrough, st)}, nil
 	} else {
 		return nil, nil
 	}
 }
 
+// follow records a control flow edge from the current block to dest, and
+// returns the state that continues execution there.
+func (self reachingState) follow(dest *BasicBlock, kind EdgeKind, st stack.StackFrame) reachingState {
+	addEdge(self.nextBlock, dest, kind)
+	return reachingState{
+		program:      self.program,
+		nextBlock:    dest,
+		stack:        st,
+		contextDepth: self.contextDepth,
+		joins:        self.joins,
+		widened:      self.widened,
+	}
+}
+
+const unresolvedJumpMessage = "Could not determine jump location statically"
+
+// joinedDefinition stands for any of several definitions, in a stack slot
+// where states reaching the same block were widened.
+type joinedDefinition struct {
+	Definitions InstructionPointerSet
+}
+
+type joinSlot struct {
+	block *BasicBlock
+	slot  int
+}
+
+// reachingJoins holds the joined definitions created by widening, so that
+// widening the same slot again reuses them and the analysis terminates.
+type reachingJoins struct {
+	slots map[joinSlot]*joinedDefinition
+}
+
+// join returns a stack value standing for both a and b, the values of a slot
+// of the stack on entry to a block.
+func (self *reachingJoins) join(block *BasicBlock, slot int, a, b interface{}) interface{} {
+	if a == b {
+		return a
+	}
+	definitions := make(InstructionPointerSet)
+	for _, value := range []interface{}{a, b} {
+		for _, definition := range stackDefinitions(value) {
+			definitions[definition] = true
+		}
+	}
+	key := joinSlot{block, slot}
+	if previous := self.slots[key]; previous != nil {
+		covered := true
+		for definition := range definitions {
+			covered = covered && previous.Definitions[definition]
+		}
+		if covered {
+			return previous
+		}
+		for definition := range previous.Definitions {
+			definitions[definition] = true
+		}
+	}
+	joined := &joinedDefinition{definitions}
+	self.slots[key] = joined
+	return joined
+}
+
+// stackDefinitions returns the definitions a stack slot's value stands for.
+func stackDefinitions(value interface{}) []InstructionPointer {
+	if joined, ok := value.(*joinedDefinition); ok {
+		return joined.Definitions.Sorted()
+	}
+	return []InstructionPointer{value.(InstructionPointer)}
+}
+
+type reachingLocation struct {
+	block   *BasicBlock
+	context string
+}
+
+func (self reachingState) Location() interface{} {
+	if self.contextDepth > 0 {
+		// Keep calling contexts apart
+		return reachingLocation{self.nextBlock, self.callContext().String()}
+	}
+	return reachingLocation{self.nextBlock, ""}
+}
+
+// Widen joins the stacks of two states entering the same block, slot by slot
+// from the top. The result is as high as the lower of the two.
+func (self reachingState) Widen(other EvmState) EvmState {
+	next := other.(reachingState)
+	height := self.stack.Height()
+	if next.stack.Height() < height {
+		height = next.stack.Height()
+	}
+	values := make([]interface{}, height)
+	a, b := self.stack, next.stack
+	for i := range values {
+		values[i] = self.joins.join(self.nextBlock, i, a.Value(), b.Value())
+		a, b = a.Up(), b.Up()
+	}
+
+	var widened stack.StackFrame = stack.StackEnd{}
+	for i := height - 1; i >= 0; i-- {
+		widened = stack.NewFrame(widened, values[i])
+	}
+	next.widened = self.widened || next.widened || self.stack.Height() != next.stack.Height()
+	next.stack = widened
+	return next
+}
+
+// jumpDestinations returns the blocks a jump at pc to the value on the stack at
+// target may reach, and whether the target could be determined statically.
+// If it can't, the jump is assumed to be able to reach any JUMPDEST.
+func (self reachingState) jumpDestinations(pc int, target interface{}) ([]*BasicBlock, bool) {
+	var dests []*BasicBlock
+	found := make(map[*BasicBlock]bool)
+	for _, definition := range stackDefinitions(target) {
+		values := foldConstant(definition, 0)
+		if values == nil {
+			self.program.Diagnostics.Warnf(AnalysisReaching, self.nextBlock, pc, unresolvedJumpMessage+"; source is @0x%X, assuming any JUMPDEST", definition.GetAddress())
+			return self.program.allJumpDestinations(), false
+		}
+		for _, value := range values {
+			if !value.IsInt64() {
+				continue
+			}
+			if dest, ok := self.program.JumpDestinations[int(value.Int64())]; ok && !found[dest] {
+				dests = append(dests, dest)
+				found[dest] = true
+			}
+		}
+	}
+	return dests, true
+}
+
+// allJumpDestinations returns every JUMPDEST block, ordered by offset.
+func (self *Program) allJumpDestinations() []*BasicBlock {
+	offsets := make([]int, 0, len(self.JumpDestinations))
+	for offset := range self.JumpDestinations {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	dests := make([]*BasicBlock, 0, len(offsets))
+	for _, offset := range offsets {
+		dests = append(dests, self.JumpDestinations[offset])
+	}
+	return dests
+}
+
+const (
+	// Maximum depth of definitions followed when folding constants
+	maxFoldDepth = 8
+	// Maximum number of distinct values a folded definition may have
+	maxFoldValues = 16
+)
+
+var wordModulus = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// foldConstant returns the values the definition at ptr may take, if they can
+// be determined by folding simple arithmetic over pushed constants, or nil if
+// they can't.
+func foldConstant(ptr InstructionPointer, depth int) []*big.Int {
+	inst := ptr.Get()
+	if inst.Op.IsPush() {
+		return []*big.Int{inst.Arg}
+	}
+	if depth >= maxFoldDepth {
+		return nil
+	}
+
+	var reaching ReachingDefinition
+	inst.Annotations.Get(&reaching)
+	if reaching == nil || len(reaching) != inst.Op.StackReads() || len(reaching) == 0 {
+		return nil
+	}
+
+	// Find the possible values of each operand
+	operands := make([][]*big.Int, len(reaching))
+	for i, pointers := range reaching {
+		for pointer := range pointers {
+			values := foldConstant(pointer, depth+1)
+			if values == nil {
+				return nil
+			}
+			operands[i] = append(operands[i], values...)
+		}
+	}
+
+	// Apply the operation to every combination of operand values
+	results := [][]*big.Int{{}}
+	for _, values := range operands {
+		var next [][]*big.Int
+		for _, args := range results {
+			for _, value := range values {
+				next = append(next, append(append([]*big.Int{}, args...), value))
+			}
+		}
+		if len(next) > maxFoldValues {
+			return nil
+		}
+		results = next
+	}
+
+	var ret []*big.Int
+	seen := make(map[string]bool)
+	for _, args := range results {
+		value := EvalOp(inst.Op, args)
+		if value == nil {
+			return nil
+		}
+		if key := value.String(); !seen[key] {
+			seen[key] = true
+			ret = append(ret, value)
+		}
+	}
+	return ret
+}
+
 type ReachesDefinition []InstructionPointer
 
 func (self ReachesDefinition) String() string {
@@ -234,7 +654,7 @@ func (self ReachesDefinition) String() string {
 func PerformReachesAnalysis(prog *Program) {
 	for _, block := range prog.Blocks {
 		for i, inst := range block.Instructions {
-			if inst.Op.IsSwap() || inst.Op.IsDup() {
+			if inst.Op.IsSwap() || inst.Op.IsDup() || inst.Op == EXCHANGE {
 				continue
 			}
 
@@ -0,0 +1,36 @@
+package evmdis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReachingBudget(t *testing.T) {
+	// Both callers of 0xF pass a target on to the JUMP at 0x17 through the
+	// ADD at 0x13, whose second definition is only seen after the jump has
+	// been explored, so the analysis takes two passes of 7 and 8 states
+	code := "346009576018600f565b601a600f565b6000016016565b565b005b00"
+	for _, test := range []struct {
+		maxStates int
+		warning   string
+	}{
+		{0, ""},
+		{15, ""},
+		// Each pass fits in the budget, but both together don't
+		{10, "Analysis truncated after exploring 10 states"},
+		{5, "Analysis truncated after exploring 5 states"},
+	} {
+		options := DefaultOptions()
+		options.Reaching.Execution.MaxStates = test.maxStates
+		prog := analyzeHex(t, code, options)
+		warning := ""
+		for _, diagnostic := range prog.Diagnostics.List {
+			if diagnostic.Analysis == AnalysisReaching {
+				warning = diagnostic.Message
+			}
+		}
+		if (test.warning == "") != (warning == "") || !strings.Contains(warning, test.warning) {
+			t.Errorf("with at most %d states: got warning %q, want %q", test.maxStates, warning, test.warning)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package signatures
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Keccak-256 as used by Ethereum, which differs from the standardised SHA3-256
+// only in its padding.
+
+const keccakRate = 136
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// Rotation of each lane, and the lane it moves to, in the combined rho and pi
+// steps, starting from lane 1
+var (
+	keccakRotations = [24]int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44}
+	keccakLanes     = [24]int{10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1}
+)
+
+func keccakF1600(a *[25]uint64) {
+	var c [5]uint64
+	for round := 0; round < 24; round++ {
+		// Theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+
+		// Rho and pi
+		current := a[1]
+		for i := 0; i < 24; i++ {
+			lane := keccakLanes[i]
+			current, a[lane] = a[lane], bits.RotateLeft64(current, keccakRotations[i])
+		}
+
+		// Chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				c[x] = a[y+x]
+			}
+			for x := 0; x < 5; x++ {
+				a[y+x] = c[x] ^ (^c[(x+1)%5] & c[(x+2)%5])
+			}
+		}
+
+		// Iota
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// Keccak256 returns the Keccak-256 hash of data.
+func Keccak256(data []byte) [32]byte {
+	var state [25]uint64
+	absorb := func(block []byte) {
+		for i := 0; i < keccakRate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(block[i*8:])
+		}
+		keccakF1600(&state)
+	}
+
+	for len(data) >= keccakRate {
+		absorb(data[:keccakRate])
+		data = data[keccakRate:]
+	}
+	last := make([]byte, keccakRate)
+	copy(last, data)
+	last[len(data)] ^= 0x01
+	last[keccakRate-1] ^= 0x80
+	absorb(last)
+
+	var hash [32]byte
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(hash[i*8:], state[i])
+	}
+	return hash
+}
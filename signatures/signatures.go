@@ -0,0 +1,139 @@
+// Package signatures names function selectors and event topics, using a
+// built-in database of common signatures and any signature files supplied by
+// the user. It works entirely offline.
+package signatures
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Resolver looks up the signatures that a selector or topic may have been
+// derived from. More than one signature is returned when hashes collide.
+type Resolver interface {
+	// Functions returns the signatures of the functions with a selector
+	Functions(selector uint32) []string
+	// Events returns the signatures of the events with a topic
+	Events(topic [32]byte) []string
+}
+
+// Selector returns the four byte selector of a function signature, such as
+// "transfer(address,uint256)".
+func Selector(signature string) uint32 {
+	hash := Keccak256([]byte(signature))
+	return binary.BigEndian.Uint32(hash[:4])
+}
+
+// Topic returns the topic an event signature, such as
+// "Transfer(address,address,uint256)", is logged with.
+func Topic(signature string) [32]byte {
+	return Keccak256([]byte(signature))
+}
+
+// Database is a Resolver backed by an in-memory set of signatures.
+type Database struct {
+	functions map[uint32][]string
+	events    map[[32]byte][]string
+}
+
+func NewDatabase() *Database {
+	return &Database{
+		functions: make(map[uint32][]string),
+		events:    make(map[[32]byte][]string),
+	}
+}
+
+//go:embed builtin.txt
+var builtinSignatures string
+
+// Builtin returns a new database containing the built-in signatures: those of
+// common token standards, access control and proxy contracts.
+func Builtin() *Database {
+	db := NewDatabase()
+	if err := db.Load(strings.NewReader(builtinSignatures)); err != nil {
+		panic(fmt.Sprintf("invalid built-in signatures: %v", err))
+	}
+	return db
+}
+
+func (self *Database) Functions(selector uint32) []string {
+	return self.functions[selector]
+}
+
+func (self *Database) Events(topic [32]byte) []string {
+	return self.events[topic]
+}
+
+func addSignature(signatures []string, signature string) []string {
+	for _, existing := range signatures {
+		if existing == signature {
+			return signatures
+		}
+	}
+	signatures = append(signatures, signature)
+	sort.Strings(signatures)
+	return signatures
+}
+
+// AddFunction adds a function signature to the database.
+func (self *Database) AddFunction(signature string) {
+	selector := Selector(signature)
+	self.functions[selector] = addSignature(self.functions[selector], signature)
+}
+
+// AddEvent adds an event signature to the database.
+func (self *Database) AddEvent(signature string) {
+	topic := Topic(signature)
+	self.events[topic] = addSignature(self.events[topic], signature)
+}
+
+var signaturePattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*\([A-Za-z0-9_,()\[\]]*\)$`)
+
+// Load adds the signatures in r to the database. Each line holds a function
+// signature, optionally preceded by "function", or an event signature preceded
+// by "event". Whitespace within signatures is ignored, and blank lines and
+// those starting with '#' are skipped.
+func (self *Database) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		add := self.AddFunction
+		if fields := strings.Fields(text); fields[0] == "function" || fields[0] == "event" {
+			if fields[0] == "event" {
+				add = self.AddEvent
+			}
+			text = strings.TrimSpace(text[len(fields[0]):])
+		}
+		signature := strings.Join(strings.Fields(text), "")
+		if !signaturePattern.MatchString(signature) {
+			return fmt.Errorf("line %d: invalid signature %q", line, text)
+		}
+		add(signature)
+	}
+	return scanner.Err()
+}
+
+// LoadFile adds the signatures in a file to the database, as described by Load.
+func (self *Database) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := self.Load(file); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
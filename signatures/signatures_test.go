@@ -0,0 +1,59 @@
+package signatures
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		hash  string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"The quick brown fox jumps over the lazy dog", "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		// Either side of the 136 byte rate, and more than one block
+		{strings.Repeat("a", 135), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{strings.Repeat("a", 136), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{strings.Repeat("a", 200), "96ea54061def936c4be90b518992fdc6f12f535068a256229aca54267b4d084d"},
+	} {
+		hash := Keccak256([]byte(test.input))
+		if got := hex.EncodeToString(hash[:]); got != test.hash {
+			t.Errorf("Keccak256 of %d bytes: got %v, want %v", len(test.input), got, test.hash)
+		}
+	}
+}
+
+func TestSelectorAndTopic(t *testing.T) {
+	if selector := Selector("transfer(address,uint256)"); selector != 0xa9059cbb {
+		t.Errorf("got selector 0x%08x, want 0xa9059cbb", selector)
+	}
+	topic := Topic("Transfer(address,address,uint256)")
+	if got := hex.EncodeToString(topic[:]); got != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("got topic %v", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	db := NewDatabase()
+	if err := db.Load(strings.NewReader("transfer(address,uint256)\nnot a signature\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want one for line 2", err)
+	}
+
+	db = NewDatabase()
+	if err := db.Load(strings.NewReader("# ERC-20\nfunction transfer(address, uint256)\n\napprove(address,uint256)\nevent Transfer(address,address,uint256)\n")); err != nil {
+		t.Fatal(err)
+	}
+	for selector, want := range map[uint32]string{0xa9059cbb: "transfer(address,uint256)", 0x095ea7b3: "approve(address,uint256)"} {
+		if got := db.Functions(selector); len(got) != 1 || got[0] != want {
+			t.Errorf("functions for 0x%08x: got %v, want %v", selector, got, want)
+		}
+	}
+	if got := db.Events(Topic("Transfer(address,address,uint256)")); len(got) != 1 {
+		t.Errorf("got events %v", got)
+	}
+	if got := db.Functions(Selector("Transfer(address,address,uint256)")); len(got) != 0 {
+		t.Errorf("event was added as a function: %v", got)
+	}
+}
@@ -51,6 +51,13 @@ func Swap(stack StackFrame, num int) StackFrame {
 	return NewFrame(up, old)
 }
 
+// Exchange swaps the items a and b places below the top of the stack.
+func Exchange(stack StackFrame, a, b int) StackFrame {
+	exchanged, old := Replace(stack, b, UpBy(stack, a).Value())
+	exchanged, _ = Replace(exchanged, a, old)
+	return exchanged
+}
+
 func String(stack StackFrame) string {
 	values := make([]interface{}, 0, stack.Height() + 1)
 	for frame := stack; frame.Height() > 0; frame = frame.Up() {
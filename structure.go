@@ -0,0 +1,517 @@
+package evmdis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is a node in the structured, pseudo-code form of a program.
+type Statement interface {
+	// Render returns the statement as lines of pseudo-code, each prefixed by
+	// indent
+	Render(indent string) string
+}
+
+type Sequence []Statement
+
+// empty returns true if the sequence contains no statements other than
+// labels.
+func (self Sequence) empty() bool {
+	for _, statement := range self {
+		if _, ok := statement.(*LabelStatement); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (self Sequence) Render(indent string) string {
+	ret := ""
+	for _, statement := range self {
+		ret += statement.Render(indent)
+	}
+	return ret
+}
+
+// ExpressionStatement is an expression evaluated for its effects, or whose
+// value is left on the stack if Push is true.
+type ExpressionStatement struct {
+	Expression Expression
+	Push       bool
+}
+
+func (self *ExpressionStatement) Render(indent string) string {
+	if self.Push {
+		return fmt.Sprintf("%vPUSH(%v)%v\n", indent, self.Expression, signatureComment(self.Expression))
+	}
+	return fmt.Sprintf("%v%v%v\n", indent, self.Expression, signatureComment(self.Expression))
+}
+
+// ReturnStatement is an instruction that halts execution.
+type ReturnStatement struct {
+	Expression Expression
+}
+
+func (self *ReturnStatement) Render(indent string) string {
+	if expression, ok := self.Expression.(*InstructionExpression); ok {
+		switch expression.Inst.Op {
+		case STOP:
+			return indent + "stop\n"
+		case RETURN, REVERT:
+			// Render as return(offset, size) or revert(offset, size)
+			text := expression.String()
+			return indent + strings.ToLower(expression.Inst.Op.String()) + text[len(expression.Inst.Op.String()):] + "\n"
+		}
+	}
+	return fmt.Sprintf("%v%v\n", indent, self.Expression)
+}
+
+// CallStatement is a call to an internal function, after which execution
+// continues at the call's return site.
+type CallStatement struct {
+	Call *InternalCall
+}
+
+func (self *CallStatement) Render(indent string) string {
+	return fmt.Sprintf("%v%v\n", indent, self.Call)
+}
+
+// LeaveStatement returns from an internal function to its caller.
+type LeaveStatement struct{}
+
+func (self *LeaveStatement) Render(indent string) string {
+	return indent + "leave\n"
+}
+
+// FunctionStatement is the body of an internal function, structured
+// separately from the code that calls it.
+type FunctionStatement struct {
+	Function *InternalFunction
+	Body     Sequence
+}
+
+func (self *FunctionStatement) Render(indent string) string {
+	return fmt.Sprintf("%v%v {\n%v%v}\n", indent, self.Function, self.Body.Render(indent+"\t"), indent)
+}
+
+// CommentStatement is a comment, such as a diagnostic about the code.
+type CommentStatement struct {
+	Text string
+}
+
+func (self *CommentStatement) Render(indent string) string {
+	return fmt.Sprintf("%v# %v\n", indent, self.Text)
+}
+
+// LabelStatement marks the start of a block's code. It is only rendered if the
+// block is the target of a goto, or starts a region of its own.
+type LabelStatement struct {
+	Block   *BasicBlock
+	Visible bool
+}
+
+func (self *LabelStatement) Render(indent string) string {
+	if !self.Visible {
+		return ""
+	}
+	return fmt.Sprintf("%v%v:\n", indent, blockName(self.Block))
+}
+
+// GotoStatement transfers control to a block that couldn't be structured.
+type GotoStatement struct {
+	Target *BasicBlock
+}
+
+func (self *GotoStatement) Render(indent string) string {
+	return fmt.Sprintf("%vgoto %v\n", indent, blockName(self.Target))
+}
+
+type BreakStatement struct{}
+
+func (self *BreakStatement) Render(indent string) string {
+	return indent + "break\n"
+}
+
+type ContinueStatement struct{}
+
+func (self *ContinueStatement) Render(indent string) string {
+	return indent + "continue\n"
+}
+
+type IfStatement struct {
+	Condition Expression
+	Then      Sequence
+	// Empty if there is no else branch
+	Else Sequence
+}
+
+func (self *IfStatement) Render(indent string) string {
+	ret := fmt.Sprintf("%vif (%v) {%v\n%v%v}", indent, self.Condition, signatureComment(self.Condition), self.Then.Render(indent+"\t"), indent)
+	if len(self.Else) == 1 {
+		if elseIf, ok := self.Else[0].(*IfStatement); ok {
+			return ret + " else " + strings.TrimPrefix(elseIf.Render(indent), indent)
+		}
+	}
+	if otherwise := self.Else.Render(indent + "\t"); otherwise != "" {
+		ret += fmt.Sprintf(" else {\n%v%v}", otherwise, indent)
+	}
+	return ret + "\n"
+}
+
+type WhileStatement struct {
+	Loop *Loop
+	// Nil if the loop only exits from within its body
+	Condition Expression
+	Body      Sequence
+}
+
+func (self *WhileStatement) Render(indent string) string {
+	condition := "true"
+	if self.Condition != nil {
+		condition = self.Condition.String()
+	}
+	return fmt.Sprintf("%vwhile (%v) {\n%v%v}\n", indent, condition, self.Body.Render(indent+"\t"), indent)
+}
+
+// signatureComment returns a comment naming the signatures annotating an
+// expression, if any.
+func signatureComment(expression Expression) string {
+	if names := ExpressionSignatures(expression); len(names) > 0 {
+		return "  # " + strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// blockName returns the name of a block in pseudo-code: its jump label if it
+// has one, or its offset otherwise.
+func blockName(block *BasicBlock) string {
+	var label *JumpLabel
+	block.Annotations.Get(&label)
+	if label != nil {
+		return label.String()
+	}
+	return fmt.Sprintf("0x%X", block.Offset)
+}
+
+// negateCondition returns the logical negation of a condition.
+func negateCondition(condition Expression) Expression {
+	if expression, ok := condition.(*InstructionExpression); ok && expression.Inst.Op == ISZERO {
+		return expression.Arguments[0]
+	}
+	return &InstructionExpression{&Instruction{Op: ISZERO}, []Expression{condition}}
+}
+
+// structureContext describes the region of code being structured.
+type structureContext struct {
+	// Innermost loop being structured, and the block execution continues at
+	// when it exits
+	loop       *Loop
+	loopFollow *BasicBlock
+	// Block at which the region ends, where the branches of a conditional
+	// rejoin
+	follow *BasicBlock
+}
+
+type structurer struct {
+	prog    *Program
+	visited map[*BasicBlock]bool
+	labels  map[*BasicBlock]*LabelStatement
+	// Blocks that end by calling an internal function, and by returning
+	// from one
+	calls   map[*BasicBlock]*InternalCall
+	returns map[*BasicBlock]bool
+	// Immediate post-dominator of each block with calls stepped over, where
+	// the branches of a conditional ending it rejoin
+	merges map[*BasicBlock]*BasicBlock
+}
+
+// StructureProgram converts an analyzed program into nested if, else, while
+// and return statements, using the expressions built for each instruction as
+// conditions and statements. Control flow that can't be structured is
+// represented with gotos, and code that can't be reached from the entry point
+// by structured control flow is given its own labelled region. Calls to
+// internal functions continue at their return sites, and each function's body
+// is structured once, after the rest of the program. Loop analysis must
+// already have been performed.
+func StructureProgram(prog *Program) Sequence {
+	self := &structurer{
+		prog:    prog,
+		visited: make(map[*BasicBlock]bool),
+		labels:  make(map[*BasicBlock]*LabelStatement),
+		calls:   make(map[*BasicBlock]*InternalCall),
+		returns: make(map[*BasicBlock]bool),
+	}
+	if prog.Entry == nil {
+		return nil
+	}
+	internal := make(map[*BasicBlock]bool)
+	for _, function := range prog.InternalFunctions {
+		for _, block := range function.Blocks {
+			internal[block] = true
+		}
+		for _, call := range function.Calls {
+			self.calls[call.Site.OriginBlock] = call
+		}
+		for _, ret := range function.Returns {
+			self.returns[ret.OriginBlock] = true
+		}
+	}
+
+	self.merges = newCallFlow(prog).postDominators(prog)
+
+	ret := self.region(prog.Entry, structureContext{})
+	ret = append(ret, self.unvisited(internal)...)
+	for _, function := range prog.InternalFunctions {
+		if !self.visited[function.Entry] {
+			ret = append(ret, &FunctionStatement{function, self.region(function.Entry, structureContext{})})
+		}
+	}
+	return append(ret, self.unvisited(nil)...)
+}
+
+// unvisited structures the reachable blocks that haven't been structured yet,
+// other than those in skip, as labelled regions of their own.
+func (self *structurer) unvisited(skip map[*BasicBlock]bool) Sequence {
+	var ret Sequence
+	for _, block := range self.prog.Blocks {
+		var reaching ReachingDefinition
+		block.Annotations.Get(&reaching)
+		if reaching == nil || self.visited[block] || skip[block] {
+			continue
+		}
+		ret = append(ret, self.region(block, structureContext{})...)
+		self.labels[block].Visible = true
+	}
+	return ret
+}
+
+// RenderPseudocode renders the structured form of an analyzed program.
+func RenderPseudocode(prog *Program) string {
+	ret := ""
+	for _, diagnostic := range prog.Diagnostics.ForBlock(nil) {
+		ret += fmt.Sprintf("# %v\n", diagnostic)
+	}
+	return ret + StructureProgram(prog).Render("") + "\n"
+}
+
+// halts returns true if execution can't continue past the end of a block.
+func halts(block *BasicBlock) bool {
+	return len(block.Successors) == 0
+}
+
+// gotoStatement returns a goto to a block that has already been structured,
+// making its label visible.
+func (self *structurer) gotoStatement(block *BasicBlock) Statement {
+	self.labels[block].Visible = true
+	return &GotoStatement{block}
+}
+
+// region structures the code starting at block, until it reaches the follow
+// block of ctx or leaves the enclosing loop.
+func (self *structurer) region(block *BasicBlock, ctx structureContext) Sequence {
+	var ret Sequence
+	for block != nil && block != ctx.follow {
+		if ctx.loop != nil {
+			if block == ctx.loop.Header {
+				return append(ret, &ContinueStatement{})
+			}
+			if !ctx.loop.Contains(block) {
+				if block == ctx.loopFollow {
+					return append(ret, &BreakStatement{})
+				}
+				// Leaving the loop for somewhere other than its follow
+				ctx = structureContext{}
+			}
+		}
+
+		// Code that halts is duplicated rather than jumped to
+		if self.visited[block] && !halts(block) {
+			return append(ret, self.gotoStatement(block))
+		}
+
+		var loop *Loop
+		block.Annotations.Get(&loop)
+		if loop != nil && loop != ctx.loop {
+			var statement Statement
+			statement, block = self.loop(loop)
+			ret = append(ret, statement)
+			continue
+		}
+
+		var statements Sequence
+		statements, block = self.block(block, ctx)
+		ret = append(ret, statements...)
+	}
+	return ret
+}
+
+// loopFollow picks the block execution continues at when a loop exits: the
+// first exit from the header that doesn't halt, or failing that the first such
+// exit from anywhere in the loop.
+func loopFollow(loop *Loop) *BasicBlock {
+	var follow *BasicBlock
+	for _, edge := range loop.Exits {
+		if halts(edge.To) {
+			continue
+		}
+		if edge.From == loop.Header {
+			return edge.To
+		}
+		if follow == nil {
+			follow = edge.To
+		}
+	}
+	return follow
+}
+
+// loop structures a loop as a while statement, returning it and the block
+// that follows the loop.
+func (self *structurer) loop(loop *Loop) (Statement, *BasicBlock) {
+	ctx := structureContext{loop: loop, loopFollow: loopFollow(loop)}
+	body, next := self.block(loop.Header, ctx)
+	body = append(body, self.region(next, ctx)...)
+
+	// Move the header's label outside the loop
+	var labels Sequence
+	for len(body) > 0 {
+		if _, ok := body[0].(*LabelStatement); !ok {
+			break
+		}
+		labels, body = append(labels, body[0]), body[1:]
+	}
+
+	statement := &WhileStatement{Loop: loop, Body: body}
+	// Turn 'while (true) { if (c) { break } ... }' into 'while (!c) { ... }'
+	if len(body) > 0 {
+		if ifStatement, ok := body[0].(*IfStatement); ok && ifStatement.Else == nil && len(ifStatement.Then) == 1 {
+			if _, ok := ifStatement.Then[0].(*BreakStatement); ok {
+				statement.Condition = negateCondition(ifStatement.Condition)
+				statement.Body = body[1:]
+			}
+		}
+	}
+	if len(statement.Body) > 0 {
+		if _, ok := statement.Body[len(statement.Body)-1].(*ContinueStatement); ok {
+			statement.Body = statement.Body[:len(statement.Body)-1]
+		}
+	}
+
+	return append(labels, statement), ctx.loopFollow
+}
+
+// terminal returns true if structuring the code at block in ctx results in a
+// single statement that leaves the region: a break, continue, goto, or code
+// that halts.
+func (self *structurer) terminal(block *BasicBlock, ctx structureContext) bool {
+	if halts(block) || self.visited[block] {
+		return true
+	}
+	return ctx.loop != nil && (block == ctx.loop.Header || block == ctx.loopFollow)
+}
+
+// block structures the code of a single block, returning its statements and
+// the block that execution continues at, if any.
+func (self *structurer) block(block *BasicBlock, ctx structureContext) (Sequence, *BasicBlock) {
+	self.visited[block] = true
+	label := self.labels[block]
+	if label == nil {
+		label = &LabelStatement{Block: block}
+		self.labels[block] = label
+	}
+	ret := Sequence{label}
+	for _, diagnostic := range self.prog.Diagnostics.ForBlock(block) {
+		ret = append(ret, &CommentStatement{diagnostic.String()})
+	}
+
+	var trueEdge, falseEdge *Edge
+	for _, edge := range block.Successors {
+		switch edge.Kind {
+		case EdgeConditionalTrue:
+			trueEdge = edge
+		case EdgeConditionalFalse:
+			falseEdge = edge
+		}
+	}
+	conditional := len(block.Successors) == 2 && trueEdge != nil && falseEdge != nil && trueEdge.To != falseEdge.To
+	unconditional := len(block.Successors) == 1 && (block.Successors[0].Kind == EdgeJump || block.Successors[0].Kind == EdgeFallthrough)
+
+	var condition Expression
+	for i := range block.Instructions {
+		inst := &block.Instructions[i]
+		var expression Expression
+		inst.Annotations.Get(&expression)
+		if expression == nil {
+			continue
+		}
+
+		last := i == len(block.Instructions)-1
+		if last && conditional {
+			// The condition is the last argument, after any jump targets
+			if expression, ok := expression.(*InstructionExpression); ok && len(expression.Arguments) > 0 {
+				condition = expression.Arguments[len(expression.Arguments)-1]
+				continue
+			}
+			conditional = false
+		}
+		if last && (unconditional || self.returns[block]) && (inst.Op == JUMP || inst.Op == RJUMP) {
+			continue
+		}
+
+		if inst.Op.Halts() || inst.Op == RETF {
+			ret = append(ret, &ReturnStatement{expression})
+		} else {
+			ret = append(ret, &ExpressionStatement{expression, self.prog.StackWrites(inst) == 1 && !inst.Op.IsDup()})
+		}
+	}
+
+	if condition == nil {
+		conditional = false
+	}
+
+	switch {
+	case self.calls[block] != nil:
+		call := self.calls[block]
+		return append(ret, &CallStatement{call}), call.ReturnSite
+	case self.returns[block]:
+		return append(ret, &LeaveStatement{}), nil
+	case conditional:
+		statements, next := self.conditional(block, condition, trueEdge.To, falseEdge.To, ctx)
+		return append(ret, statements...), next
+	case len(block.Successors) == 1:
+		return ret, block.Successors[0].To
+	}
+	// Either execution halts, or the block jumps to one of several places
+	// that are structured separately
+	return ret, nil
+}
+
+// conditional structures a conditional branch at the end of block, returning
+// its statements and the block at which the branches rejoin, if any.
+func (self *structurer) conditional(block *BasicBlock, condition Expression, trueTarget, falseTarget *BasicBlock, ctx structureContext) (Sequence, *BasicBlock) {
+	// Branches that immediately leave the region are guard clauses, and the
+	// other branch continues after them
+	if self.terminal(falseTarget, ctx) {
+		return Sequence{&IfStatement{negateCondition(condition), self.region(falseTarget, ctx), nil}}, trueTarget
+	}
+	if self.terminal(trueTarget, ctx) {
+		return Sequence{&IfStatement{condition, self.region(trueTarget, ctx), nil}}, falseTarget
+	}
+
+	// Otherwise the branches rejoin at the immediate post-dominator, if it's
+	// in the same loop. Calls are stepped over, so that a function called from
+	// both branches isn't taken for the point they rejoin at.
+	merge := self.merges[block]
+	if merge != nil && ctx.loop != nil && !ctx.loop.Contains(merge) {
+		merge = nil
+	}
+
+	inner := ctx
+	if merge != nil {
+		inner.follow = merge
+	}
+	statement := &IfStatement{condition, self.region(trueTarget, inner), self.region(falseTarget, inner)}
+	if statement.Then.empty() {
+		statement = &IfStatement{negateCondition(condition), statement.Else, statement.Then}
+	}
+	return Sequence{statement}, merge
+}
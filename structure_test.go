@@ -0,0 +1,24 @@
+package evmdis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStructureSharedCallee(t *testing.T) {
+	// Both branches of the JUMPI at 0x3 call the function at 0x15, and rejoin
+	// at the STOP at 0x13, where the second call returns to
+	prog := analyzeHex(t, "34600d5760096015565b6013565b60136015565b005b56", DefaultOptions())
+	if len(prog.InternalFunctions) != 1 {
+		t.Fatalf("got %d internal functions, want 1", len(prog.InternalFunctions))
+	}
+	pseudocode := RenderPseudocode(prog)
+	for _, want := range []string{"} else {", "}\nstop\ninternal function fn_label3() {\n\tleave\n}"} {
+		if !strings.Contains(pseudocode, want) {
+			t.Errorf("got pseudocode\n%v\nwant it to contain %q", pseudocode, want)
+		}
+	}
+	if strings.Count(pseudocode, "leave") != 1 {
+		t.Errorf("got pseudocode\n%v\nwant the function's body once", pseudocode)
+	}
+}
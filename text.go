@@ -0,0 +1,196 @@
+package evmdis
+
+import (
+	"fmt"
+	"strings"
+)
+
+const dataBytesPerLine = 32
+
+// RenderText renders an analyzed program as text: the expressions of each
+// block, preceded by its label, diagnostics and stack prestate. Blocks are
+// grouped by the function they belong to, and data regions are printed as hex.
+func RenderText(program *Program) (disassembly string) {
+	disassembly += renderDiagnostics(program.Diagnostics.ForBlock(nil))
+
+	// Blocks of internal functions are printed in sections of their own
+	printed := make(map[*BasicBlock]bool)
+	internal := make(map[*BasicBlock]bool)
+	for _, function := range program.InternalFunctions {
+		for _, block := range function.Blocks {
+			internal[block] = true
+		}
+	}
+	printBlocks := func(blocks []*BasicBlock) {
+		for _, block := range blocks {
+			if !printed[block] && !internal[block] {
+				disassembly += renderBlock(program, block)
+				printed[block] = true
+			}
+		}
+	}
+
+	if dispatcher := program.Dispatcher; dispatcher != nil {
+		// Group the blocks by the external function they belong to
+		functions := fmt.Sprintf("%d functions", len(dispatcher.Functions))
+		if len(dispatcher.Functions) == 1 {
+			functions = "1 function"
+		}
+		disassembly += fmt.Sprintf("# Dispatcher (%v, %v", dispatcher.Style, functions)
+		if dispatcher.Fallback != nil {
+			var label *JumpLabel
+			dispatcher.Fallback.Annotations.Get(&label)
+			if label != nil {
+				disassembly += fmt.Sprintf(", fallback %v", label)
+			} else {
+				disassembly += fmt.Sprintf(", fallback at 0x%X", dispatcher.Fallback.Offset)
+			}
+		}
+		disassembly += fmt.Sprintln(") -------------------------")
+		printBlocks(dispatcher.Blocks)
+		for _, function := range dispatcher.Functions {
+			if len(function.Signatures) > 0 {
+				disassembly += fmt.Sprintf("%v  # %v\n", function, strings.Join(function.Signatures, " or "))
+			} else {
+				disassembly += fmt.Sprintf("%v\n", function)
+			}
+			printBlocks(function.Blocks)
+		}
+	}
+
+	var rest []*BasicBlock
+	for _, block := range program.Blocks {
+		if !printed[block] && !internal[block] {
+			rest = append(rest, block)
+		}
+	}
+	if len(rest) > 0 && len(printed) > 0 {
+		disassembly += fmt.Sprintln("# Shared code -------------------------")
+	}
+	printBlocks(rest)
+
+	for _, function := range program.InternalFunctions {
+		disassembly += fmt.Sprintf("%v\n", function)
+		for _, block := range function.Blocks {
+			if !printed[block] {
+				disassembly += renderBlock(program, block)
+				printed[block] = true
+			}
+		}
+	}
+
+	for _, region := range program.DataRegions {
+		disassembly += renderDataRegion(region)
+	}
+
+	return disassembly
+}
+
+// renderBlock renders a block's label, stack prestate and expressions.
+func renderBlock(program *Program, block *BasicBlock) (disassembly string) {
+	offset := block.Offset
+
+	// Print out the jump label for the block, if there is one, and
+	// whether it's a loop header
+	header := ""
+	var label *JumpLabel
+	block.Annotations.Get(&label)
+	var loop *Loop
+	block.Annotations.Get(&loop)
+	if loop != nil {
+		backEdges := fmt.Sprintf("%d back edges", len(loop.BackEdges))
+		if len(loop.BackEdges) == 1 {
+			backEdges = "1 back edge"
+		}
+		if label != nil {
+			header = fmt.Sprintf("%v  # loop header, %v\n", label, backEdges)
+		} else {
+			header = fmt.Sprintf("# loop header, %v\n", backEdges)
+		}
+	} else if label != nil {
+		header = fmt.Sprintf("%v\n", label)
+	}
+
+	// Print out the stack prestate for this block
+	var reaching ReachingDefinition
+	block.Annotations.Get(&reaching)
+
+	diagnostics := program.Diagnostics.ForBlock(block)
+	blockDisassembly := renderDiagnostics(diagnostics)
+	blockDisassembly += fmt.Sprintf("# Stack: %v\n", reaching)
+	var contexts ContextReachingDefinition
+	block.Annotations.Get(&contexts)
+	if len(contexts) > 1 {
+		for _, context := range contexts {
+			blockDisassembly += fmt.Sprintf("#   in context %v: %v\n", context.Context, context.Reaching)
+		}
+	}
+	blockRealInstructions := 0
+
+	for _, instruction := range block.Instructions {
+		var expression Expression
+		instruction.Annotations.Get(&expression)
+
+		if expression != nil {
+			if program.StackWrites(&instruction) == 1 && !instruction.Op.IsDup() {
+				blockDisassembly += fmt.Sprintf("0x%X\tPUSH(%v)", offset, expression)
+			} else {
+				blockDisassembly += fmt.Sprintf("0x%X\t%v", offset, expression)
+			}
+			comments := ExpressionSignatures(expression)
+			var branch Branch
+			instruction.Annotations.Get(&branch)
+			if branch != BranchEither {
+				comments = append(comments, branch.String())
+			}
+			if len(comments) > 0 {
+				blockDisassembly += fmt.Sprintf("  # %v", strings.Join(comments, ", "))
+			}
+			blockDisassembly += fmt.Sprintln()
+
+			blockRealInstructions++
+		}
+		offset += instruction.Size()
+	}
+
+	// Note calls made by falling through to an internal function
+	if len(block.Instructions) > 0 {
+		var call *InternalCall
+		block.Instructions[len(block.Instructions)-1].Annotations.Get(&call)
+		if call != nil && call.Fallthrough {
+			blockDisassembly += fmt.Sprintf("# Falls through to %v\n", &CallExpression{Call: call})
+		}
+	}
+
+	blockDisassembly += fmt.Sprintf("\n")
+
+	// avoid printing empty stack frames with no instructions in the block,
+	// or a label with nothing after it
+	if len(reaching) > 0 || blockRealInstructions > 0 || len(diagnostics) > 0 {
+		disassembly += header + blockDisassembly
+	}
+
+	return disassembly
+}
+
+func renderDiagnostics(diagnostics []*Diagnostic) (disassembly string) {
+	for _, diagnostic := range diagnostics {
+		disassembly += fmt.Sprintf("# %v\n", diagnostic)
+	}
+	return disassembly
+}
+
+func renderDataRegion(region *DataRegion) (disassembly string) {
+	if region.Reason != "" {
+		disassembly += fmt.Sprintf("# Data: %v (%v bytes)\n", region.Reason, len(region.Data))
+	}
+	for i := 0; i < len(region.Data); i += dataBytesPerLine {
+		end := i + dataBytesPerLine
+		if end > len(region.Data) {
+			end = len(region.Data)
+		}
+		disassembly += fmt.Sprintf("0x%X\tDATA(0x%X)\n", region.Offset+i, region.Data[i:end])
+	}
+	disassembly += fmt.Sprintf("\n")
+	return disassembly
+}
@@ -0,0 +1,54 @@
+package evmdis
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected output in testdata")
+
+// TestRenderText compares the text output for each program in tests with the
+// output in testdata, which go test -update regenerates.
+func TestRenderText(t *testing.T) {
+	paths, err := filepath.Glob("tests/*.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytecode, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		result, err := Analyze(bytecode, DefaultOptions())
+		if err != nil {
+			t.Errorf("%v: %v", path, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(path), ".bin")+".txt")
+		if *update {
+			if err := os.WriteFile(golden, []byte(result.Output), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Output != string(expected) {
+			t.Errorf("%v: output differs from %v; run go test -update to accept it", path, golden)
+		}
+	}
+}
@@ -0,0 +1,828 @@
+package evmdis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+const (
+	// Maximum number of values a ValueSet enumerates before it's treated as an
+	// interval
+	maxValueSetSize = 16
+	// Number of times a block's entry stack may change before its intervals are
+	// widened, so that loops reach a fixpoint
+	valueWidenAfter = 3
+)
+
+var addressMask = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+
+// ValueSet is an abstract stack value: the interval [Min, Max], and if it has
+// few enough members, the exact values in it.
+type ValueSet struct {
+	// Possible values in ascending order, or nil if there are too many
+	Values []*big.Int
+	Min    *big.Int
+	Max    *big.Int
+}
+
+func NewConstantValue(value *big.Int) *ValueSet {
+	return &ValueSet{[]*big.Int{value}, value, value}
+}
+
+// NewRangeValue returns the interval [min, max], enumerated if it's small.
+func NewRangeValue(min, max *big.Int) *ValueSet {
+	if min.Cmp(max) > 0 {
+		return nil
+	}
+	size := new(big.Int).Sub(max, min)
+	if size.Cmp(big.NewInt(maxValueSetSize)) >= 0 {
+		return &ValueSet{nil, min, max}
+	}
+	values := make([]*big.Int, 0, size.Int64()+1)
+	for value := new(big.Int).Set(min); value.Cmp(max) <= 0; value = new(big.Int).Add(value, big.NewInt(1)) {
+		values = append(values, value)
+	}
+	return &ValueSet{values, min, max}
+}
+
+// newValueSet returns the set of values, or the interval spanning them if there
+// are too many.
+func newValueSet(values []*big.Int) *ValueSet {
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+	unique := values[:0]
+	for _, value := range values {
+		if len(unique) == 0 || unique[len(unique)-1].Cmp(value) != 0 {
+			unique = append(unique, value)
+		}
+	}
+	if len(unique) > maxValueSetSize {
+		return &ValueSet{nil, unique[0], unique[len(unique)-1]}
+	}
+	return &ValueSet{unique, unique[0], unique[len(unique)-1]}
+}
+
+// TopValue returns the value set of a word that may take any value.
+func TopValue() *ValueSet {
+	return &ValueSet{nil, big.NewInt(0), wordMask}
+}
+
+func (self *ValueSet) IsTop() bool {
+	return self.Values == nil && self.Min.Sign() == 0 && self.Max.Cmp(wordMask) == 0
+}
+
+// Constant returns the only value in the set, or nil if there's more than one.
+func (self *ValueSet) Constant() *big.Int {
+	if len(self.Values) == 1 {
+		return self.Values[0]
+	}
+	return nil
+}
+
+func (self *ValueSet) Contains(value *big.Int) bool {
+	if self.Values == nil {
+		return self.Min.Cmp(value) <= 0 && self.Max.Cmp(value) >= 0
+	}
+	for _, member := range self.Values {
+		if member.Cmp(value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *ValueSet) Equals(other *ValueSet) bool {
+	if self.Min.Cmp(other.Min) != 0 || self.Max.Cmp(other.Max) != 0 || len(self.Values) != len(other.Values) {
+		return false
+	}
+	for i := range self.Values {
+		if self.Values[i].Cmp(other.Values[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *ValueSet) String() string {
+	if self.IsTop() {
+		return "any"
+	}
+	if self.Values == nil {
+		return fmt.Sprintf("[0x%X, 0x%X]", self.Min, self.Max)
+	}
+	if len(self.Values) == 1 {
+		return fmt.Sprintf("0x%X", self.Values[0])
+	}
+	values := make([]string, 0, len(self.Values))
+	for _, value := range self.Values {
+		values = append(values, fmt.Sprintf("0x%X", value))
+	}
+	return fmt.Sprintf("{%v}", strings.Join(values, ", "))
+}
+
+// intersect returns the members of the set in [min, max], or nil if there are
+// none.
+func (self *ValueSet) intersect(min, max *big.Int) *ValueSet {
+	if self.Values == nil {
+		return NewRangeValue(bigMax(self.Min, min), bigMin(self.Max, max))
+	}
+	var values []*big.Int
+	for _, value := range self.Values {
+		if value.Cmp(min) >= 0 && value.Cmp(max) <= 0 {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return newValueSet(values)
+}
+
+// without returns the set with value removed, or nil if nothing is left.
+func (self *ValueSet) without(value *big.Int) *ValueSet {
+	if self.Values == nil {
+		// Only the ends of an interval can be removed
+		if self.Min.Cmp(value) == 0 {
+			return NewRangeValue(new(big.Int).Add(value, big.NewInt(1)), self.Max)
+		} else if self.Max.Cmp(value) == 0 {
+			return NewRangeValue(self.Min, new(big.Int).Sub(value, big.NewInt(1)))
+		}
+		return self
+	}
+	var values []*big.Int
+	for _, member := range self.Values {
+		if member.Cmp(value) != 0 {
+			values = append(values, member)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return newValueSet(values)
+}
+
+func bigMin(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+func bigMax(a, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return a
+	}
+	return b
+}
+
+// joinValues returns a value set containing the members of both.
+func joinValues(a, b *ValueSet) *ValueSet {
+	if a.Values != nil && b.Values != nil {
+		return newValueSet(append(append([]*big.Int{}, a.Values...), b.Values...))
+	}
+	return &ValueSet{nil, bigMin(a.Min, b.Min), bigMax(a.Max, b.Max)}
+}
+
+// widenValues joins next into previous, moving any bound that changed to the
+// end of the word's range.
+func widenValues(previous, next *ValueSet) *ValueSet {
+	joined := joinValues(previous, next)
+	if joined.Min.Cmp(previous.Min) == 0 && joined.Max.Cmp(previous.Max) == 0 {
+		return joined
+	}
+	min, max := joined.Min, joined.Max
+	if min.Cmp(previous.Min) < 0 {
+		min = big.NewInt(0)
+	}
+	if max.Cmp(previous.Max) > 0 {
+		max = wordMask
+	}
+	return NewRangeValue(min, max)
+}
+
+// maskRange returns [0, 2 ** n - 1] for the smallest n that covers value.
+func maskRange(value *big.Int) *ValueSet {
+	mask := new(big.Int).Lsh(big.NewInt(1), uint(value.BitLen()))
+	return NewRangeValue(big.NewInt(0), mask.Sub(mask, big.NewInt(1)))
+}
+
+// EvalValueOp applies op to abstract arguments, in the order they're popped
+// from the stack, returning the set of values it may produce.
+func EvalValueOp(op OpCode, args []*ValueSet) *ValueSet {
+	if len(args) != op.StackReads() {
+		return TopValue()
+	}
+
+	// Small sets are evaluated exhaustively
+	combinations := 1
+	for _, arg := range args {
+		combinations *= len(arg.Values)
+	}
+	if len(args) > 0 && combinations > 0 && combinations <= maxValueSetSize*maxValueSetSize {
+		if values := evalCombinations(op, args); values != nil {
+			return newValueSet(values)
+		}
+	}
+
+	one := big.NewInt(1)
+	boolean := NewRangeValue(big.NewInt(0), one)
+	switch op {
+	case ADD:
+		max := new(big.Int).Add(args[0].Max, args[1].Max)
+		if max.Cmp(wordMask) <= 0 {
+			return NewRangeValue(new(big.Int).Add(args[0].Min, args[1].Min), max)
+		}
+	case SUB:
+		if args[0].Min.Cmp(args[1].Max) >= 0 {
+			return NewRangeValue(new(big.Int).Sub(args[0].Min, args[1].Max), new(big.Int).Sub(args[0].Max, args[1].Min))
+		}
+	case MUL:
+		max := new(big.Int).Mul(args[0].Max, args[1].Max)
+		if max.Cmp(wordMask) <= 0 {
+			return NewRangeValue(new(big.Int).Mul(args[0].Min, args[1].Min), max)
+		}
+	case DIV:
+		if args[1].Min.Sign() > 0 {
+			return NewRangeValue(new(big.Int).Div(args[0].Min, args[1].Max), new(big.Int).Div(args[0].Max, args[1].Min))
+		}
+		return NewRangeValue(big.NewInt(0), args[0].Max)
+	case MOD:
+		if args[1].Max.Sign() == 0 {
+			return NewConstantValue(big.NewInt(0))
+		}
+		return NewRangeValue(big.NewInt(0), bigMin(args[0].Max, new(big.Int).Sub(args[1].Max, one)))
+	case AND:
+		return NewRangeValue(big.NewInt(0), bigMin(args[0].Max, args[1].Max))
+	case OR:
+		max := maskRange(bigMax(args[0].Max, args[1].Max)).Max
+		return NewRangeValue(bigMax(args[0].Min, args[1].Min), max)
+	case XOR:
+		return maskRange(bigMax(args[0].Max, args[1].Max))
+	case SHR:
+		shift := args[0].Max
+		if shift.Cmp(big.NewInt(256)) >= 0 {
+			shift = big.NewInt(256)
+		}
+		min := new(big.Int).Rsh(args[1].Min, uint(shift.Uint64()))
+		if args[0].Min.Cmp(big.NewInt(256)) >= 0 {
+			return NewConstantValue(big.NewInt(0))
+		}
+		return NewRangeValue(min, new(big.Int).Rsh(args[1].Max, uint(args[0].Min.Uint64())))
+	case SHL:
+		if args[0].Max.Cmp(big.NewInt(256)) < 0 {
+			max := new(big.Int).Lsh(args[1].Max, uint(args[0].Max.Uint64()))
+			if max.Cmp(wordMask) <= 0 {
+				return NewRangeValue(new(big.Int).Lsh(args[1].Min, uint(args[0].Min.Uint64())), max)
+			}
+		}
+	case LT, GT:
+		a, b := args[0], args[1]
+		if op == GT {
+			a, b = b, a
+		}
+		if a.Max.Cmp(b.Min) < 0 {
+			return NewConstantValue(one)
+		} else if a.Min.Cmp(b.Max) >= 0 {
+			return NewConstantValue(big.NewInt(0))
+		}
+		return boolean
+	case EQ:
+		if args[0].Max.Cmp(args[1].Min) < 0 || args[1].Max.Cmp(args[0].Min) < 0 {
+			return NewConstantValue(big.NewInt(0))
+		}
+		return boolean
+	case ISZERO:
+		if args[0].Min.Sign() > 0 {
+			return NewConstantValue(big.NewInt(0))
+		} else if args[0].Max.Sign() == 0 {
+			return NewConstantValue(one)
+		}
+		return boolean
+	case SLT, SGT, CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		return boolean
+	case BYTE:
+		return NewRangeValue(big.NewInt(0), big.NewInt(0xFF))
+	case ADDRESS, CALLER, ORIGIN, COINBASE, CREATE, CREATE2:
+		return NewRangeValue(big.NewInt(0), addressMask)
+	}
+	return TopValue()
+}
+
+// evalCombinations evaluates op over every combination of the values of its
+// arguments, or returns nil if op doesn't only depend on its arguments.
+func evalCombinations(op OpCode, args []*ValueSet) []*big.Int {
+	var results []*big.Int
+	operands := make([]*big.Int, len(args))
+	var visit func(int) bool
+	visit = func(i int) bool {
+		if i == len(args) {
+			result := EvalOp(op, operands)
+			if result == nil {
+				return false
+			}
+			results = append(results, result)
+			return true
+		}
+		for _, value := range args[i].Values {
+			operands[i] = value
+			if !visit(i + 1) {
+				return false
+			}
+		}
+		return true
+	}
+	if !visit(0) {
+		return nil
+	}
+	return results
+}
+
+// Branch annotates a conditional jump whose condition value analysis found to
+// be constant.
+type Branch int
+
+const (
+	BranchEither Branch = iota
+	BranchAlways
+	BranchNever
+)
+
+func (self Branch) String() string {
+	switch self {
+	case BranchAlways:
+		return "always jumps"
+	case BranchNever:
+		return "never jumps"
+	}
+	return "may jump"
+}
+
+// valueID identifies a stack item within one visit to a block: the index of
+// the instruction that produced it, or -1 - n for the nth item from the top of
+// the stack on entry.
+type valueID struct {
+	block *BasicBlock
+	index int
+}
+
+type valueItem struct {
+	value *ValueSet
+	id    valueID
+	// Comparison the item is the result of, if it was computed in this block
+	condition *valueCondition
+}
+
+type valueCondition struct {
+	op   OpCode
+	args []valueItem
+}
+
+type valueAnalysis struct {
+	program *Program
+	// Joined stack on entry to each block reached, bottom first
+	entries map[*BasicBlock][]*ValueSet
+	changes map[*BasicBlock]int
+	// Incremented each time a block's entry stack changes, so that superseded
+	// states can be skipped
+	versions map[*BasicBlock]int
+	feasible map[*Edge]bool
+	// Jumps whose target couldn't be narrowed to a set of values
+	unresolved map[*BasicBlock]bool
+	// Whether each conditional jump was found to be taken and not taken
+	taken    map[*BasicBlock]bool
+	notTaken map[*BasicBlock]bool
+	// Set if a path was cut short, so that the edges and blocks it would have
+	// reached can't be told apart from infeasible ones
+	incomplete bool
+}
+
+type valueState struct {
+	analysis *valueAnalysis
+	block    *BasicBlock
+	version  int
+}
+
+// enter joins a stack into the entry stack of a block, returning the state to
+// visit the block in, or nil if the block has already been visited with it.
+func (self *valueAnalysis) enter(block *BasicBlock, stack []valueItem) EvmState {
+	values := make([]*ValueSet, len(stack))
+	for i, item := range stack {
+		values[i] = item.value
+	}
+
+	previous, ok := self.entries[block]
+	if ok {
+		// A block entered at different heights keeps the highest, with the
+		// slots missing from the lower stacks taking any value. Once it's
+		// widened, it stops growing, so that recursion reaches a fixpoint.
+		if self.changes[block] >= valueWidenAfter && len(values) > len(previous) {
+			values = values[len(values)-len(previous):]
+		}
+		changed := len(values) > len(previous)
+		previous = padValues(previous, len(values))
+		values = padValues(values, len(previous))
+		for i := range values {
+			if self.changes[block] >= valueWidenAfter {
+				values[i] = widenValues(previous[i], values[i])
+			} else {
+				values[i] = joinValues(previous[i], values[i])
+			}
+			changed = changed || !values[i].Equals(previous[i])
+		}
+		if !changed {
+			return nil
+		}
+		self.changes[block]++
+	}
+	self.entries[block] = values
+	self.versions[block]++
+	return valueState{self, block, self.versions[block]}
+}
+
+// padValues returns a stack of at least height values, adding values that may
+// take any value to the bottom of stack.
+func padValues(stack []*ValueSet, height int) []*ValueSet {
+	if len(stack) >= height {
+		return stack
+	}
+	padded := make([]*ValueSet, height-len(stack), height)
+	for i := range padded {
+		padded[i] = TopValue()
+	}
+	return append(padded, stack...)
+}
+
+// annotate joins the value produced by an instruction into its annotation.
+func annotateValue(inst *Instruction, value *ValueSet) {
+	var previous *ValueSet
+	inst.Annotations.Get(&previous)
+	if previous != nil {
+		value = joinValues(previous, value)
+	}
+	inst.Annotations.Set(&value)
+}
+
+// refine narrows the items on stack that are the same as item, and the
+// operands of the comparison it's the result of, to the values for which item
+// is nonzero if truth is set, or zero if not. It returns nil if there are no
+// such values.
+func refine(stack []valueItem, item valueItem, truth bool) []valueItem {
+	var value *ValueSet
+	if truth {
+		value = item.value.intersect(big.NewInt(1), wordMask)
+	} else {
+		value = item.value.intersect(big.NewInt(0), big.NewInt(0))
+	}
+	if value == nil {
+		return nil
+	}
+	stack = narrowItem(stack, item, value)
+	if stack == nil || item.condition == nil {
+		return stack
+	}
+
+	args := item.condition.args
+	switch item.condition.op {
+	case ISZERO:
+		return refine(stack, args[0], !truth)
+	case LT, GT:
+		// Refine as a < b, or its negation a >= b
+		a, b := args[0], args[1]
+		if item.condition.op == GT {
+			a, b = b, a
+		}
+		one := big.NewInt(1)
+		if truth {
+			if b.value.Max.Sign() == 0 {
+				return nil
+			}
+			stack = narrowItem(stack, a, a.value.intersect(big.NewInt(0), new(big.Int).Sub(b.value.Max, one)))
+			if stack != nil {
+				stack = narrowItem(stack, b, b.value.intersect(new(big.Int).Add(a.value.Min, one), wordMask))
+			}
+		} else {
+			stack = narrowItem(stack, a, a.value.intersect(b.value.Min, wordMask))
+			if stack != nil {
+				stack = narrowItem(stack, b, b.value.intersect(big.NewInt(0), a.value.Max))
+			}
+		}
+	case EQ:
+		for i, arg := range args {
+			other := args[1-i].value.Constant()
+			if other == nil {
+				continue
+			}
+			if truth {
+				stack = narrowItem(stack, arg, arg.value.intersect(other, other))
+			} else {
+				stack = narrowItem(stack, arg, arg.value.without(other))
+			}
+			if stack == nil {
+				return nil
+			}
+		}
+	}
+	return stack
+}
+
+// narrowItem returns a copy of stack with every copy of item replaced by value,
+// or nil if value is empty.
+func narrowItem(stack []valueItem, item valueItem, value *ValueSet) []valueItem {
+	if value == nil {
+		return nil
+	}
+	if item.id.block == nil {
+		return stack
+	}
+	narrowed := make([]valueItem, len(stack))
+	for i, slot := range stack {
+		narrowed[i] = slot
+		if slot.id == item.id {
+			narrowed[i].value = value
+		}
+	}
+	return narrowed
+}
+
+func (self valueState) Advance() ([]EvmState, error) {
+	analysis := self.analysis
+	prog := analysis.program
+	block := self.block
+	if analysis.versions[block] != self.version {
+		// The block's entry stack has changed since this state was queued
+		return nil, nil
+	}
+
+	entry := analysis.entries[block]
+	stack := make([]valueItem, len(entry))
+	for i, value := range entry {
+		stack[i] = valueItem{value: value, id: valueID{block, i - len(entry)}}
+	}
+
+	for i := range block.Instructions {
+		inst := &block.Instructions[i]
+		op := inst.Op
+		reads := prog.StackReads(inst)
+		if len(stack) < reads {
+			// The reaching analysis followed this path, so the control flow
+			// graph can't be refined from a run that cut it short
+			prog.Diagnostics.Errorf(AnalysisValues, block, block.OffsetOf(inst), "Stack underflow: %v reads %v items, but the stack has %v; results are incomplete", op, reads, len(stack))
+			analysis.incomplete = true
+			return nil, nil
+		}
+		// Operands in the order they're popped
+		operands := make([]valueItem, reads)
+		for j := range operands {
+			operands[j] = stack[len(stack)-1-j]
+		}
+
+		switch {
+		case op.Halts() || op == RETF || op == JUMPF:
+			return nil, nil
+		case op.IsPush():
+			value := NewConstantValue(inst.Arg)
+			annotateValue(inst, value)
+			stack = append(stack, valueItem{value: value, id: valueID{block, i}})
+		case op.IsDup():
+			stack = append(stack, operands[reads-1])
+		case op.IsSwap():
+			stack = append([]valueItem{}, stack...)
+			top, other := len(stack)-1, len(stack)-reads
+			stack[top], stack[other] = stack[other], stack[top]
+		case op == EXCHANGE:
+			stack = append([]valueItem{}, stack...)
+			a, b := exchangeDepths(inst)
+			a, b = len(stack)-1-a, len(stack)-1-b
+			stack[a], stack[b] = stack[b], stack[a]
+		case op == JUMP || op == JUMPI || op == RJUMP || op == RJUMPI || op == RJUMPV:
+			return self.branch(op, operands, stack[:len(stack)-reads]), nil
+		default:
+			stack = stack[:len(stack)-reads]
+			writes := prog.StackWrites(inst)
+			if writes == 1 && op.StackWrites() == 1 {
+				args := make([]*ValueSet, reads)
+				for j, operand := range operands {
+					args[j] = operand.value
+				}
+				item := valueItem{value: EvalValueOp(op, args), id: valueID{block, i}}
+				switch op {
+				case LT, GT, EQ, ISZERO:
+					item.condition = &valueCondition{op, operands}
+				}
+				annotateValue(inst, item.value)
+				stack = append(stack, item)
+			} else {
+				for j := 0; j < writes; j++ {
+					stack = append(stack, valueItem{value: TopValue(), id: valueID{block, i}})
+				}
+			}
+		}
+	}
+
+	return self.follow(func(edge *Edge) []valueItem { return stack }), nil
+}
+
+// branch follows the edges out of a block ending in a jump that are feasible
+// for the values of its target and condition.
+func (self valueState) branch(op OpCode, operands []valueItem, stack []valueItem) []EvmState {
+	analysis := self.analysis
+	var target, condition *valueItem
+	switch op {
+	case JUMP:
+		target = &operands[0]
+	case JUMPI:
+		target, condition = &operands[0], &operands[1]
+	case RJUMPI:
+		condition = &operands[0]
+	}
+
+	var targets map[*BasicBlock]bool
+	if target != nil {
+		if target.value.Values == nil {
+			analysis.unresolved[self.block] = true
+		} else {
+			targets = make(map[*BasicBlock]bool)
+			for _, value := range target.value.Values {
+				if !value.IsInt64() {
+					continue
+				}
+				if dest, ok := analysis.program.JumpDestinations[int(value.Int64())]; ok {
+					targets[dest] = true
+				}
+			}
+		}
+	}
+
+	// Stacks for when the condition holds and when it doesn't, or nil if it
+	// can't
+	taken, notTaken := stack, stack
+	if condition != nil {
+		taken = refine(stack, *condition, true)
+		notTaken = refine(stack, *condition, false)
+		analysis.taken[self.block] = analysis.taken[self.block] || taken != nil
+		analysis.notTaken[self.block] = analysis.notTaken[self.block] || notTaken != nil
+	}
+
+	return self.follow(func(edge *Edge) []valueItem {
+		if edge.Kind == EdgeConditionalFalse {
+			return notTaken
+		}
+		if targets != nil && !targets[edge.To] {
+			return nil
+		}
+		return taken
+	})
+}
+
+// follow visits the successors of the block on the edges that stackFor returns
+// a stack for.
+func (self valueState) follow(stackFor func(*Edge) []valueItem) []EvmState {
+	var ret []EvmState
+	for _, edge := range self.block.Successors {
+		stack := stackFor(edge)
+		if stack == nil {
+			continue
+		}
+		self.analysis.feasible[edge] = true
+		if state := self.analysis.enter(edge.To, stack); state != nil {
+			ret = append(ret, state)
+		}
+	}
+	return ret
+}
+
+// PerformValueAnalysis computes the set or range of values each instruction
+// may produce, annotating it with a *ValueSet, and uses them to refine the
+// control flow graph. Edges that can't be taken, such as the branches of a
+// JUMPI whose condition is constant, are removed, along with the blocks only
+// they reach. Jumps whose target the reaching analysis couldn't determine are
+// narrowed to the targets their values select, as in a jump table. Conditional
+// jumps found to always or never be taken are annotated with their Branch.
+// Reaching analysis must already have been performed, and reaches analysis must
+// be performed afterwards.
+func PerformValueAnalysis(prog *Program) error {
+	return PerformValueAnalysisWithOptions(prog, DefaultReachingOptions().Execution)
+}
+
+// PerformValueAnalysisWithOptions is PerformValueAnalysis, bounded by options.
+func PerformValueAnalysisWithOptions(prog *Program, options ExecutionOptions) error {
+	return PerformValueAnalysisContext(context.Background(), prog, options)
+}
+
+// PerformValueAnalysisContext is PerformValueAnalysisWithOptions, stopping with
+// ctx.Err() if ctx is cancelled. Instructions visited until then are annotated
+// with the values found so far, but the control flow graph is only refined once
+// the analysis has finished, so it's left as it was. The same goes if the
+// budget runs out, or a path is cut short by a stack underflow; either is
+// recorded as a diagnostic.
+func PerformValueAnalysisContext(ctx context.Context, prog *Program, options ExecutionOptions) error {
+	if prog.Entry == nil {
+		return fmt.Errorf("Reaching analysis has not been performed")
+	}
+	analysis := &valueAnalysis{
+		program:    prog,
+		entries:    make(map[*BasicBlock][]*ValueSet),
+		changes:    make(map[*BasicBlock]int),
+		versions:   make(map[*BasicBlock]int),
+		feasible:   make(map[*Edge]bool),
+		unresolved: make(map[*BasicBlock]bool),
+		taken:      make(map[*BasicBlock]bool),
+		notTaken:   make(map[*BasicBlock]bool),
+	}
+	var inputs []valueItem
+	if prog.Inputs != nil {
+		for range prog.Inputs.Instructions {
+			inputs = append(inputs, valueItem{value: TopValue()})
+		}
+	}
+	err := ExecuteAbstractlyContext(ctx, analysis.enter(prog.Entry, inputs), options)
+	var truncated *TruncatedError
+	if errors.As(err, &truncated) {
+		prog.Diagnostics.Warnf(AnalysisValues, nil, -1, "%v; results are incomplete", truncated)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if analysis.incomplete {
+		return nil
+	}
+
+	resolved := make(map[*BasicBlock]bool)
+	for _, block := range prog.Blocks {
+		if _, ok := analysis.entries[block]; !ok {
+			// Unreachable; forget what the reaching analysis found
+			var reaching ReachingDefinition
+			var contexts ContextReachingDefinition
+			block.Annotations.Pop(&reaching)
+			block.Annotations.Pop(&contexts)
+			for i := range block.Instructions {
+				block.Instructions[i].Annotations.Pop(&reaching)
+				block.Instructions[i].Annotations.Pop(&contexts)
+			}
+		}
+
+		successors := block.Successors[:0]
+		for _, edge := range block.Successors {
+			if analysis.feasible[edge] {
+				successors = append(successors, edge)
+			}
+		}
+		block.Successors = successors
+		predecessors := block.Predecessors[:0]
+		for _, edge := range block.Predecessors {
+			if analysis.feasible[edge] {
+				predecessors = append(predecessors, edge)
+			}
+		}
+		block.Predecessors = predecessors
+
+		if len(block.Instructions) == 0 {
+			continue
+		}
+		last := &block.Instructions[len(block.Instructions)-1]
+		if last.Op == JUMPI || last.Op == RJUMPI {
+			branch := BranchEither
+			if !analysis.notTaken[block] && analysis.taken[block] {
+				branch = BranchAlways
+			} else if !analysis.taken[block] && analysis.notTaken[block] {
+				branch = BranchNever
+			}
+			if branch != BranchEither {
+				last.Annotations.Set(&branch)
+			}
+		}
+		if analysis.unresolved[block] {
+			continue
+		}
+		for _, edge := range block.Successors {
+			if edge.Kind == EdgeUnresolved {
+				resolved[block] = true
+				edge.Kind = EdgeJump
+				if last.Op == JUMPI {
+					edge.Kind = EdgeConditionalTrue
+				}
+			}
+		}
+	}
+
+	// Drop the reaching analysis's complaints about code that can't be reached
+	// and jumps that have now been resolved
+	prog.Diagnostics.Filter(func(diagnostic *Diagnostic) bool {
+		if diagnostic.Analysis == AnalysisReaching && diagnostic.Block != nil {
+			if _, ok := analysis.entries[diagnostic.Block]; !ok {
+				return false
+			}
+			if resolved[diagnostic.Block] && strings.HasPrefix(diagnostic.Message, unresolvedJumpMessage) {
+				return false
+			}
+		}
+		return true
+	})
+
+	prog.Exits = nil
+	prog.findExits()
+	return nil
+}
@@ -0,0 +1,52 @@
+package evmdis
+
+import (
+	"testing"
+)
+
+func TestValueAnalysis(t *testing.T) {
+	// PUSH1 0x1 PUSH1 0x6 JUMPI STOP JUMPDEST STOP: the JUMPI always jumps
+	prog := analyzeHex(t, "6001600657005b00", DefaultOptions())
+	jumpi := findOp(t, prog, JUMPI)
+	var branch Branch
+	jumpi.Get().Annotations.Get(&branch)
+	if branch != BranchAlways {
+		t.Errorf("got branch %v, want %v", branch, BranchAlways)
+	}
+	if successors := jumpi.OriginBlock.Successors; len(successors) != 1 || successors[0].To != prog.JumpDestinations[0x6] {
+		t.Errorf("got successors %v, want only the jump", successors)
+	}
+
+	var value *ValueSet
+	findOp(t, prog, PUSH1).Get().Annotations.Get(&value)
+	if value == nil || value.Constant() == nil || value.Constant().Int64() != 1 {
+		t.Errorf("got value %v for the condition, want 0x1", value)
+	}
+}
+
+func TestValueStackHeights(t *testing.T) {
+	// A function at 0x15 that only returns is called at a stack height of
+	// one, returning to 0x9, and at four, returning to 0x19, which pops the
+	// extra items and jumps on to an SSTORE
+	prog := analyzeHex(t, "3460095760176015565b60116022603360196015565b565b005b5050601f565b600160005500", DefaultOptions())
+	sstore := findOp(t, prog, SSTORE)
+	var reaching ReachingDefinition
+	sstore.Get().Annotations.Get(&reaching)
+	if reaching == nil || len(sstore.OriginBlock.Predecessors) == 0 {
+		t.Errorf("the SSTORE was found unreachable")
+	}
+	for _, diagnostic := range prog.Diagnostics.List {
+		t.Errorf("unexpected diagnostic %v", diagnostic)
+	}
+
+	// PUSH1 0x1 ADD underflows, so nothing can be concluded from the values
+	// found
+	prog = analyzeHex(t, "600101", DefaultOptions())
+	found := false
+	for _, diagnostic := range prog.Diagnostics.List {
+		found = found || (diagnostic.Analysis == AnalysisValues && diagnostic.Severity == SeverityError)
+	}
+	if !found {
+		t.Errorf("got diagnostics %v, want an underflow from the value analysis", prog.Diagnostics.List)
+	}
+}